@@ -2,6 +2,7 @@ package goutils
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"path/filepath"
 	"runtime"
@@ -113,6 +114,19 @@ func (sc *ServerContext) Critical(format string, args ...interface{}) {
 	Log.Critical(s, args...)
 }
 
+type serverContextKey struct{}
+
+// WithServerContext 把ServerContext放入context，随http请求传递给拦截器等组件
+func WithServerContext(ctx context.Context, sc *ServerContext) context.Context {
+	return context.WithValue(ctx, serverContextKey{}, sc)
+}
+
+// ServerContextFrom 从context中取出ServerContext，没有时返回nil
+func ServerContextFrom(ctx context.Context) *ServerContext {
+	sc, _ := ctx.Value(serverContextKey{}).(*ServerContext)
+	return sc
+}
+
 func getRuntime(skip int) (function, filename string, lineno int) {
 	function = "???"
 	pc, filename, lineno, ok := runtime.Caller(skip)
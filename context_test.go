@@ -1,6 +1,9 @@
 package goutils
 
-import "testing"
+import (
+	stdcontext "context"
+	"testing"
+)
 
 func Test_NewContext(t *testing.T) {
 	context := NewContext("test")
@@ -47,3 +50,14 @@ func Test_Log(t *testing.T) {
 	context.Critical("critical")
 	context.Notice("Notice")
 }
+
+func Test_ServerContextFrom(t *testing.T) {
+	sc := NewContext("test")
+	ctx := WithServerContext(stdcontext.Background(), sc)
+	if ServerContextFrom(ctx) != sc {
+		t.Fail()
+	}
+	if ServerContextFrom(stdcontext.Background()) != nil {
+		t.Fail()
+	}
+}
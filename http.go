@@ -1,29 +1,83 @@
 package goutils
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"sync"
 	"sync/atomic"
 	"time"
 )
 
 var (
-	errorRequestPoolFull    = errors.New("ERROR_HTTP_REQUEST_POOL_FULL")
-	errorRequestCallTimeout = errors.New("ERROR_HTTP_REQUEST_TIMEOUNT")
-	errorRequestNil         = errors.New("ERROR_HTTP_REQUEST_NIL")
-	defaultPoolNum          = 100
+	errorRequestPoolFull     = errors.New("ERROR_HTTP_REQUEST_POOL_FULL")
+	errorRequestCallTimeout  = errors.New("ERROR_HTTP_REQUEST_TIMEOUNT")
+	errorRequestNil          = errors.New("ERROR_HTTP_REQUEST_NIL")
+	errorRequestPoolClosed   = errors.New("ERROR_HTTP_REQUEST_POOL_CLOSED")
+	errorRequestQueueTimeout = errors.New("ERROR_HTTP_REQUEST_QUEUE_TIMEOUT")
+	errorRequestShed         = errors.New("ERROR_HTTP_REQUEST_SHED")
+	errorRequestRateLimited  = errors.New("ERROR_HTTP_REQUEST_RATE_LIMITED")
+	defaultPoolNum           = 100
 )
 
 //HTTPData http请求和响应
 type HTTPData struct {
-	Request   *http.Request
-	Response  *http.Response
-	Err       error
-	ExtraData interface{} //http 请求的自定义信息
-	ended     chan bool
+	Request        *http.Request
+	Response       *http.Response
+	Err            error
+	ExtraData      interface{}    //http 请求的自定义信息
+	Timeout        time.Duration  //单独设置的超时时间，0表示使用连接池超时时间
+	Deadline       time.Time      //单独设置的截止时间，和Timeout同时设置时取较早的
+	Priority       HTTPPriority   //请求优先级，连接池饱和时优先丢弃BestEffort请求
+	QueueWait      time.Duration  //从提交到worker开始执行的排队时间
+	ExecTime       time.Duration  //worker执行请求的时间
+	Stream         bool           //流式响应，worker一直被占用到body读完或者关闭，截止时间覆盖读取body的时间
+	Progress       ProgressFunc   //读取响应body的进度回调
+	UploadProgress ProgressFunc   //上传请求body的进度回调
+	Fallback       HTTPFallback   //单独设置的降级处理，优先于连接池的设置
+	FallbackFrom   error          //触发降级的原始错误，nil表示没有降级
+	ServerContext  *ServerContext //记录访问日志的ServerContext，nil时从请求的context中获取
+	Validate       HTTPValidator  //在worker中检查响应，nil时要求2xx，只在设置了Decode或者Validate时生效
+	Decode         HTTPDecoder    //在worker中解析响应，结果保存到Result
+	Result         interface{}    //Decode的返回值
+	DecodeErr      error          //读取、检查或者解析响应的错误
+	ended          chan bool
+	enqueued       time.Time
+	deadline       time.Time //提交时计算出的实际截止时间
+	state          int32
+	cache          *httpCacheLookup //没有命中缓存时记录缓存key和需要重新验证的旧响应
+	coalesced      bool             //批量请求中以合并方式执行
+	partitions     []*httpPartition //占用的分区，每次入队占用一个，重复使用时上一轮的worker可能还没有释放
+	partitionLock  sync.Mutex
+	retries        int             //对冲等额外发出的请求次数
+	decoded        bool            //已经执行过Validate和Decode
+	ctx            context.Context //扇出请求使用的context，nil时使用Request的context
 }
 
+// HTTPPriority 请求优先级
+type HTTPPriority int
+
+const (
+	// PriorityNormal 普通请求，连接池满时按准入策略处理
+	PriorityNormal HTTPPriority = iota
+	// PriorityCritical 关键请求，连接池满时即使准入策略是立即拒绝也会排队等待，并且先于普通请求被处理
+	PriorityCritical
+	// PriorityBestEffort 尽力而为的请求，连接池接近饱和时直接丢弃，不排队等待
+	PriorityBestEffort
+)
+
+// HTTPData.state低2位为状态，其余位为执行轮次，重复使用HTTPData时上一轮的worker不能再写入结果
+const (
+	httpDataPending int32 = iota
+	httpDataDone
+	httpDataAbandoned
+
+	httpDataStateBits = 2
+	httpDataStateMask = 1<<httpDataStateBits - 1
+)
+
 //NewHTTPData HTTPData constructor
 func NewHTTPData(request *http.Request) *HTTPData {
 	return &HTTPData{Request: request, Response: nil, Err: nil, ended: make(chan bool, 1)}
@@ -34,114 +88,742 @@ func NewHTTPDataWithExtra(request *http.Request, extra interface{}) *HTTPData {
 	return &HTTPData{Request: request, Response: nil, Err: nil, ended: make(chan bool, 1), ExtraData: extra}
 }
 
+// requestContext 执行请求使用的context，扇出请求不修改Request，使用各自派生的context
+func (d *HTTPData) requestContext() context.Context {
+	if d.ctx != nil {
+		return d.ctx
+	}
+	return d.Request.Context()
+}
+
+// round 当前执行轮次
+func (d *HTTPData) round() int32 {
+	return atomic.LoadInt32(&d.state) >> httpDataStateBits
+}
+
+// reset 开始新一轮执行，清除上一轮的状态和没有被读取的完成通知
+func (d *HTTPData) reset() {
+	atomic.StoreInt32(&d.state, (d.round()+1)<<httpDataStateBits|httpDataPending)
+	select {
+	case <-d.ended:
+	default:
+	}
+}
+
+// complete worker完成第round轮请求时调用，调用方已经放弃等待或者已经开始新一轮时返回false
+func (d *HTTPData) complete(round int32) bool {
+	return atomic.CompareAndSwapInt32(&d.state, round<<httpDataStateBits|httpDataPending, round<<httpDataStateBits|httpDataDone)
+}
+
+// finish 设置当前轮次的请求结果并通知调用方
+func (d *HTTPData) finish(response *http.Response, err error) bool {
+	return d.finishRound(d.round(), response, err)
+}
+
+func (d *HTTPData) finishRound(round int32, response *http.Response, err error) bool {
+	if !d.complete(round) {
+		return false
+	}
+	d.Response, d.Err = response, err
+	d.ended <- true
+	return true
+}
+
+// abandon 调用方放弃等待，worker已经完成时等待其写完结果并返回false
+func (d *HTTPData) abandon(err error) bool {
+	state := atomic.LoadInt32(&d.state)
+	if state&httpDataStateMask != httpDataPending || !atomic.CompareAndSwapInt32(&d.state, state, state&^httpDataStateMask|httpDataAbandoned) {
+		<-d.ended
+		return false
+	}
+	d.Response, d.Err = nil, err
+	return true
+}
+
+// cancelBody 读取完body并关闭时取消请求的context
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
 //HTTPConnectionPool http连接池
 type HTTPConnectionPool struct {
-	name        string
-	timeout     time.Duration  //超时时间
-	poolNum     int            //连接池数目
-	requestPool chan *HTTPData //连接池
-	httpClient  *http.Client
-	timeoutNum  int64 //超时请求次数
-	poolFullNum int64 //连接池满次数
-	totalNum    int64 //总请求次数
+	name            string
+	lock            *sync.Mutex
+	timeout         time.Duration  //超时时间
+	poolNum         int            //连接池数目
+	workerNum       int            //当前运行的worker数目
+	requestPool     chan *HTTPData //连接池
+	shrink          chan bool      //缩容通知，close后空闲worker检查是否需要退出
+	admitLock       *sync.RWMutex  //入队时持读锁，关闭时持写锁，保证关闭后没有新请求入队
+	closing         chan bool      //close后不再接受新请求
+	quit            chan bool      //close后所有worker退出
+	closeOnce       *sync.Once
+	quitOnce        *sync.Once
+	pendingNum      int64 //排队中和正在执行的请求数
+	activeNum       int64 //正在执行的请求数
+	httpClient      *http.Client
+	adaptive        *httpAdaptive
+	admission       HTTPAdmission
+	hedger          *httpHedger
+	upstream        *HTTPUpstream
+	limiter         *httpLimiter
+	cache           *httpCache
+	coalescer       *httpCoalescer
+	faults          *HTTPFaultInjector
+	fallback        HTTPFallback
+	bulkheads       *httpBulkheads
+	accessLog       *httpAccessLog
+	recentErrors    *httpErrorRing
+	interceptors    []Interceptor
+	chain           RoundTripFunc  //拦截器链
+	maxResponseSize int64          //DoJSON等方法读取响应的最大字节数
+	bufferSize      int64          //大于0时worker把响应读到内存中
+	latency         *latencyWindow //最近成功请求的耗时
+	waitQueue       *httpWaitQueue
+	lastStats       httpPoolStats //上次调用Status时的累计统计
+	timeoutNum      int64         //超时请求次数
+	poolFullNum     int64         //连接池满次数
+	totalNum        int64         //总请求次数
+	stats           httpPoolStats
+}
+
+// httpPoolStats 累计统计，Status不会清零，供自适应调整等使用
+type httpPoolStats struct {
+	doneNum        int64 //完成请求次数
+	execNanos      int64 //请求执行总耗时
+	queueNanos     int64 //请求排队总耗时
+	hedgeNum       int64 //对冲请求次数
+	rateLimitedNum int64 //被限流拒绝的请求次数
+	cacheHitNum    int64 //直接由缓存返回的请求次数
+	coalescedNum   int64 //合并到其他请求的请求次数
+	fallbackNum    int64 //执行降级处理的请求次数
+	poolFullNum    int64
+	timeoutNum     int64
+}
+
+func (cp *HTTPConnectionPool) loadStats() httpPoolStats {
+	return httpPoolStats{
+		doneNum:        atomic.LoadInt64(&cp.stats.doneNum),
+		execNanos:      atomic.LoadInt64(&cp.stats.execNanos),
+		queueNanos:     atomic.LoadInt64(&cp.stats.queueNanos),
+		hedgeNum:       atomic.LoadInt64(&cp.stats.hedgeNum),
+		rateLimitedNum: atomic.LoadInt64(&cp.stats.rateLimitedNum),
+		cacheHitNum:    atomic.LoadInt64(&cp.stats.cacheHitNum),
+		coalescedNum:   atomic.LoadInt64(&cp.stats.coalescedNum),
+		fallbackNum:    atomic.LoadInt64(&cp.stats.fallbackNum),
+		poolFullNum:    atomic.LoadInt64(&cp.stats.poolFullNum),
+		timeoutNum:     atomic.LoadInt64(&cp.stats.timeoutNum),
+	}
 }
 
 //NewHTTPConnectionPool http连接池构造函数
 func NewHTTPConnectionPool(timeout time.Duration, poolNum int) *HTTPConnectionPool {
-	if poolNum < 0 {
-		poolNum = defaultPoolNum
-	}
+	return NewHTTPConnectionPoolWithOptions(WithTimeout(timeout), WithPoolNum(poolNum))
+}
+
+// NewHTTPConnectionPoolWithOptions 使用配置项构造http连接池，未指定的配置使用默认值
+func NewHTTPConnectionPoolWithOptions(options ...HTTPPoolOption) *HTTPConnectionPool {
+	opts := newHTTPPoolOptions(options)
 	pool := new(HTTPConnectionPool)
-	pool.name = "default"
-	pool.timeout = timeout
-	pool.poolNum = poolNum
-	pool.requestPool = make(chan *HTTPData, poolNum)
-	pool.httpClient = &http.Client{
-		Transport: &http.Transport{
-			MaxIdleConnsPerHost: poolNum * 6,
-		},
-		Timeout: timeout,
-	}
-	go func() {
-		for i := 0; i < poolNum; i++ {
-			go pool.newWorker()
-			time.Sleep(time.Millisecond * 5) //avoid connection  frequency limit
-		}
-	}()
+	pool.name = opts.name
+	pool.lock = new(sync.Mutex)
+	pool.timeout = opts.timeout
+	pool.poolNum = opts.poolNum
+	pool.requestPool = make(chan *HTTPData, opts.poolNum)
+	pool.shrink = make(chan bool)
+	pool.admitLock = new(sync.RWMutex)
+	pool.closing = make(chan bool)
+	pool.quit = make(chan bool)
+	pool.closeOnce = new(sync.Once)
+	pool.quitOnce = new(sync.Once)
+	pool.httpClient = opts.newClient()
+	pool.admission = opts.admission
+	pool.waitQueue = newHTTPWaitQueue()
+	pool.latency = newLatencyWindow(latencyWindowSize)
+	pool.upstream = opts.upstream
+	pool.maxResponseSize = opts.maxResponseSize
+	pool.bufferSize = opts.bufferSize
+	pool.faults = opts.faults
+	pool.fallback = opts.fallback
+	pool.recentErrors = newHTTPErrorRing(recentErrorNum)
+	if opts.hedge != nil {
+		pool.SetHedge(opts.hedge)
+	}
+	if opts.rateLimit != nil {
+		pool.SetRateLimit(opts.rateLimit)
+	}
+	if opts.cache != nil {
+		pool.SetCache(opts.cache)
+	}
+	if opts.coalesce != nil {
+		pool.SetCoalesce(opts.coalesce)
+	}
+	if opts.bulkheads != nil {
+		pool.SetBulkheads(opts.bulkheads)
+	}
+	if opts.accessLog != nil {
+		pool.SetAccessLog(opts.accessLog)
+	}
+	go pool.startWorkers()
+	httpPools.register(pool)
 	return pool
 }
 
 // SetName 设置连接池名字，方便统计
 func (cp *HTTPConnectionPool) SetName(name string) {
+	cp.lock.Lock()
 	cp.name = name
+	cp.lock.Unlock()
+}
+
+// GetName 获取连接池名字
+func (cp *HTTPConnectionPool) GetName() string {
+	cp.lock.Lock()
+	defer cp.lock.Unlock()
+	return cp.name
+}
+
+// SetPoolNum 运行时调整worker数目，可在SignalReload.Reload中调用
+// 扩容时逐个启动worker，缩容时空闲worker立即退出，忙碌的worker处理完当前请求后退出
+// 请求队列长度在构造时确定，不随worker数目变化
+func (cp *HTTPConnectionPool) SetPoolNum(poolNum int) {
+	if poolNum < 0 {
+		poolNum = defaultPoolNum
+	}
+	cp.lock.Lock()
+	grow := poolNum > cp.poolNum
+	cp.poolNum = poolNum
+	if !grow {
+		close(cp.shrink)
+		cp.shrink = make(chan bool)
+	}
+	cp.lock.Unlock()
+	if grow {
+		go cp.startWorkers()
+	}
+}
+
+// GetPoolNum 获取当前配置的worker数目
+func (cp *HTTPConnectionPool) GetPoolNum() int {
+	cp.lock.Lock()
+	defer cp.lock.Unlock()
+	return cp.poolNum
+}
+
+// SetTimeout 运行时调整请求超时时间，对之后发起的请求生效
+func (cp *HTTPConnectionPool) SetTimeout(timeout time.Duration) {
+	cp.lock.Lock()
+	cp.timeout = timeout
+	cp.lock.Unlock()
+}
+
+// GetTimeout 获取当前请求超时时间
+func (cp *HTTPConnectionPool) GetTimeout() time.Duration {
+	cp.lock.Lock()
+	defer cp.lock.Unlock()
+	return cp.timeout
+}
+
+func (cp *HTTPConnectionPool) getClient() *http.Client {
+	cp.lock.Lock()
+	defer cp.lock.Unlock()
+	return cp.httpClient
+}
+
+// startWorkers 启动worker直到数目达到poolNum
+func (cp *HTTPConnectionPool) startWorkers() {
+	for {
+		cp.lock.Lock()
+		if cp.workerNum >= cp.poolNum || cp.isQuit() {
+			cp.lock.Unlock()
+			return
+		}
+		cp.workerNum++
+		cp.lock.Unlock()
+		go cp.newWorker()
+		time.Sleep(time.Millisecond * 5) //avoid connection  frequency limit
+	}
+}
+
+// retireWorker worker数目超过poolNum时返回true，调用方worker需要退出
+func (cp *HTTPConnectionPool) retireWorker() bool {
+	cp.lock.Lock()
+	defer cp.lock.Unlock()
+	if cp.workerNum > cp.poolNum {
+		cp.workerNum--
+		return true
+	}
+	return false
+}
+
+func (cp *HTTPConnectionPool) getShrink() chan bool {
+	cp.lock.Lock()
+	defer cp.lock.Unlock()
+	return cp.shrink
 }
 
 func (cp *HTTPConnectionPool) newWorker() {
 	for {
+		select {
+		case request := <-cp.requestPool:
+			cp.admitWaiting()
+			cp.execute(request)
+		case <-cp.getShrink():
+		case <-cp.quit:
+			cp.lock.Lock()
+			cp.workerNum--
+			cp.lock.Unlock()
+			return
+		}
+		if cp.retireWorker() {
+			return
+		}
+	}
+}
 
-		request := <-cp.requestPool
-		if request.Request != nil {
-			request.Response, request.Err = cp.httpClient.Do(request.Request)
-		} else {
-			request.Response, request.Err = nil, errorRequestNil
+// execute worker执行请求，超过截止时间的请求不再发出
+// 截止时间通过context控制，覆盖读取body的时间，body关闭后释放
+// 流式请求在body读完或者关闭之前不释放worker
+func (cp *HTTPConnectionPool) execute(httpData *HTTPData) {
+	defer atomic.AddInt64(&cp.pendingNum, -1)
+	defer httpData.releasePartition()
+	atomic.AddInt64(&cp.activeNum, 1)
+	defer atomic.AddInt64(&cp.activeNum, -1)
+	round := httpData.round()
+	start := time.Now()
+	queueWait := start.Sub(httpData.enqueued)
+	atomic.AddInt64(&cp.stats.queueNanos, int64(queueWait))
+	if httpData.Request == nil {
+		httpData.finishRound(round, nil, errorRequestNil)
+		return
+	}
+	if !start.Before(httpData.deadline) {
+		httpData.finishRound(round, nil, errorRequestCallTimeout)
+		return
+	}
+	ctx, cancel := context.WithDeadline(httpData.requestContext(), httpData.deadline)
+	request := httpData.Request.WithContext(ctx)
+	wrapUpload(request, httpData.UploadProgress)
+	if httpData.cache != nil {
+		httpData.cache.condition(request)
+	}
+	response, err := cp.roundTrip(request)
+	if err != nil && ctx.Err() == context.DeadlineExceeded && httpData.requestContext().Err() == nil {
+		// 截止时间已到，和调用方等待超时返回同样的错误
+		err = errorRequestCallTimeout
+	}
+	if httpData.cache != nil {
+		response, err = httpData.cache.update(request, response, err)
+	}
+	var stream *streamBody
+	if err != nil {
+		cancel()
+	} else {
+		cp.observeRateLimit(httpData.Request, response)
+		stream = wrapStream(httpData, response)
+		response.Body = &cancelBody{ReadCloser: response.Body, cancel: cancel}
+		if bufferSize := cp.getBufferSize(); bufferSize > 0 && stream == nil {
+			response, err = bufferResponse(response, bufferSize)
 		}
-		request.ended <- true
+	}
+	var result interface{}
+	var decodeErr error
+	decoded := err == nil && httpData.needDecode()
+	if decoded {
+		result, decodeErr = decodeBody(httpData, httpData.Request, response, cp.getMaxResponseSize())
+	}
+	execTime := time.Since(start)
+	atomic.AddInt64(&cp.stats.doneNum, 1)
+	atomic.AddInt64(&cp.stats.execNanos, int64(execTime))
+	if err == nil {
+		cp.latency.add(execTime)
+	}
+	if !httpData.complete(round) {
+		// 调用方已经放弃等待，没有人会再关闭body
+		CloseResponse(response)
+		return
+	}
+	if err == errorRequestCallTimeout {
+		cp.addTimeout()
+	}
+	httpData.Response, httpData.Err = response, err
+	httpData.QueueWait, httpData.ExecTime = queueWait, execTime
+	httpData.Result, httpData.DecodeErr, httpData.decoded = result, decodeErr, decoded
+	httpData.ended <- true
+	if stream != nil {
+		holdStream(ctx, stream, response)
 	}
 }
 
-// Request http请求接口
-func (cp *HTTPConnectionPool) Request(request *http.Request) (*http.Response, error) {
+// roundTrip 经过拦截器链发出请求，设置了后端节点时先改写请求的host
+func (cp *HTTPConnectionPool) roundTrip(request *http.Request) (*http.Response, error) {
+	send := cp.getChain()
+	upstream := cp.getUpstream()
+	if upstream == nil {
+		return send(request)
+	}
+	ep, err := upstream.pick(request)
+	if err != nil {
+		return nil, err
+	}
+	response, err := send(upstream.bind(ep, ep.rewrite(request)))
+	upstream.release(ep)
+	return response, err
+}
+
+func (cp *HTTPConnectionPool) addPoolFull() {
+	atomic.AddInt64(&cp.poolFullNum, 1)
+	atomic.AddInt64(&cp.stats.poolFullNum, 1)
+}
+
+func (cp *HTTPConnectionPool) addTimeout() {
+	atomic.AddInt64(&cp.timeoutNum, 1)
+	atomic.AddInt64(&cp.stats.timeoutNum, 1)
+}
+
+// enqueue 请求入队，连接池已满时按准入策略和优先级拒绝或者等待
+func (cp *HTTPConnectionPool) enqueue(httpData *HTTPData) error {
+	admission := cp.GetAdmission()
+	if httpData.Priority == PriorityBestEffort && cp.saturated(admission) {
+		cp.addPoolFull()
+		return errorRequestShed
+	}
+	err := cp.tryEnqueue(httpData)
+	if err != errorRequestPoolFull {
+		return err
+	}
+	if httpData.Priority == PriorityBestEffort {
+		cp.addPoolFull()
+		return errorRequestShed
+	}
+	if admission.Policy == AdmissionReject && httpData.Priority != PriorityCritical {
+		cp.addPoolFull()
+		return err
+	}
+	return cp.waitAdmission(httpData, admission)
+}
+
+// saturated 连接池队列使用率达到ShedRatio或者已经有请求在等待
+func (cp *HTTPConnectionPool) saturated(admission HTTPAdmission) bool {
+	if cp.waitQueue.len() > 0 {
+		return true
+	}
+	size := cap(cp.requestPool)
+	return size > 0 && float64(len(cp.requestPool)) >= admission.ShedRatio*float64(size)
+}
+
+// prepare 提交前初始化HTTPData，计算实际截止时间
+func (cp *HTTPConnectionPool) prepare(httpData *HTTPData, deadline time.Time) {
+	now := time.Now()
+	if httpData.Timeout > 0 {
+		deadline = now.Add(httpData.Timeout)
+	}
+	if !httpData.Deadline.IsZero() && httpData.Deadline.Before(deadline) {
+		deadline = httpData.Deadline
+	}
+	httpData.reset()
+	httpData.enqueued = now
+	httpData.deadline = deadline
+	httpData.cache = nil
+	httpData.coalesced = false
+	httpData.FallbackFrom = nil
+	httpData.retries = 0
+	httpData.ctx = nil
+	httpData.Result, httpData.DecodeErr, httpData.decoded = nil, nil, false
 	atomic.AddInt64(&cp.totalNum, 1)
-	httpData := NewHTTPData(request)
+}
+
+// submit 获取限流令牌并占用分区后请求入队，失败时直接设置结果
+func (cp *HTTPConnectionPool) submit(httpData *HTTPData) {
+	err := cp.rateLimit(httpData)
+	if err == nil {
+		err = cp.acquirePartition(httpData)
+	}
+	if err == nil {
+		if err = cp.enqueue(httpData); err != nil {
+			httpData.releasePartition()
+		}
+	}
+	if err != nil {
+		httpData.finish(nil, err)
+	}
+}
+
+// wait 等待请求完成，超过截止时间或者请求的context结束后放弃
+func (cp *HTTPConnectionPool) wait(httpData *HTTPData) {
+	timer := time.NewTimer(time.Until(httpData.deadline))
+	defer timer.Stop()
+	var done <-chan struct{}
+	if httpData.Request != nil {
+		done = httpData.requestContext().Done()
+	}
 	select {
-	case cp.requestPool <- httpData:
+	case <-httpData.ended:
+	case <-timer.C:
+		if httpData.abandon(errorRequestCallTimeout) {
+			cp.addTimeout()
+		}
+	case <-done:
+		httpData.abandon(httpData.requestContext().Err())
+	}
+}
+
+// tryEnqueue 请求非阻塞入队，连接池已关闭或者已满时返回错误
+func (cp *HTTPConnectionPool) tryEnqueue(httpData *HTTPData) error {
+	return cp.pushRequest(httpData, cp.closing)
+}
+
+// admitEnqueue 等待队列中的请求入队，优雅关闭期间已经在等待的请求仍可入队，worker退出后返回错误
+func (cp *HTTPConnectionPool) admitEnqueue(httpData *HTTPData) error {
+	return cp.pushRequest(httpData, cp.quit)
+}
+
+// pushRequest closed关闭后返回连接池关闭错误，连接池满时返回错误
+func (cp *HTTPConnectionPool) pushRequest(httpData *HTTPData, closed chan bool) error {
+	cp.admitLock.RLock()
+	defer cp.admitLock.RUnlock()
+	select {
+	case <-closed:
+		return errorRequestPoolClosed
 	default:
-		atomic.AddInt64(&cp.poolFullNum, 1)
-		return nil, errorRequestPoolFull
 	}
+	atomic.AddInt64(&cp.pendingNum, 1)
 	select {
-	case <-httpData.ended:
-		return httpData.Response, httpData.Err
-	case <-time.After(cp.timeout):
-		atomic.AddInt64(&cp.timeoutNum, 1)
-		return nil, errorRequestCallTimeout
+	case cp.requestPool <- httpData:
+		return nil
+	default:
+		atomic.AddInt64(&cp.pendingNum, -1)
+		return errorRequestPoolFull
+	}
+}
+
+// Request http请求接口
+func (cp *HTTPConnectionPool) Request(request *http.Request) (*http.Response, error) {
+	httpData := NewHTTPData(request)
+	cp.Do(httpData)
+	return httpData.Response, httpData.Err
+}
+
+// Do 按HTTPData自带的超时时间和优先级执行请求，结果保存在httpData中
+// 开启对冲时符合条件的请求按对冲方式执行，被拒绝或者超时时执行降级处理
+func (cp *HTTPConnectionPool) Do(httpData *HTTPData) error {
+	cp.prepare(httpData, time.Now().Add(cp.GetTimeout()))
+	cp.dispatch(httpData)
+	cp.runFallback(httpData)
+	cp.settle(httpData)
+	return httpData.Err
+}
+
+// settle 请求结束后解析没有在worker中解析的响应，并记录访问日志和最近错误
+func (cp *HTTPConnectionPool) settle(httpData *HTTPData) {
+	cp.decodeResult(httpData)
+	cp.logAccess(httpData)
+	cp.recordError(httpData)
+}
+
+// dispatch 依次尝试缓存、合并请求，最后按对冲或者普通方式执行，连接池已关闭时直接返回错误
+func (cp *HTTPConnectionPool) dispatch(httpData *HTTPData) {
+	if cp.isClosing() {
+		httpData.Response, httpData.Err = nil, errorRequestPoolClosed
+		return
+	}
+	if cp.lookupCache(httpData) {
+		cp.wait(httpData)
+		return
+	}
+	if c := cp.getCoalescer(); c != nil && c.eligible(httpData) {
+		cp.doCoalesced(httpData, c)
+		return
+	}
+	cp.doRequest(httpData)
+}
+
+// doRequest 按对冲或者普通方式执行请求
+func (cp *HTTPConnectionPool) doRequest(httpData *HTTPData) {
+	if h := cp.getHedger(); h != nil && h.eligible(httpData) {
+		cp.doHedged(httpData, h)
+		return
 	}
+	cp.submit(httpData)
+	cp.wait(httpData)
 }
 
-// BatchRequest http批量请求接口
+// BatchRequest http批量请求接口，整批请求共用一个连接池超时时间，
+// 单个请求设置的超时时间或者截止时间更早时以单个请求的为准
 func (cp *HTTPConnectionPool) BatchRequest(httpDatas []*HTTPData) {
+	deadline := time.Now().Add(cp.GetTimeout())
+	coalescer := cp.getCoalescer()
+	coalesced := new(sync.WaitGroup)
 	for _, httpData := range httpDatas {
-		atomic.AddInt64(&cp.totalNum, 1)
-		select {
-		case cp.requestPool <- httpData:
-		default:
-			atomic.AddInt64(&cp.poolFullNum, 1)
-			httpData.Response = nil
-			httpData.Err = errorRequestPoolFull
-			httpData.ended <- true
+		cp.prepare(httpData, deadline)
+		if httpData.deadline.After(deadline) {
+			httpData.deadline = deadline
+		}
+		if cp.isClosing() {
+			httpData.finish(nil, errorRequestPoolClosed)
+			continue
+		}
+		if cp.lookupCache(httpData) {
+			continue
+		}
+		if coalescer != nil && coalescer.eligible(httpData) {
+			httpData.coalesced = true
+			coalesced.Add(1)
+			go func(httpData *HTTPData) {
+				defer coalesced.Done()
+				cp.doCoalesced(httpData, coalescer)
+			}(httpData)
+			continue
 		}
+		cp.submit(httpData)
 	}
 	for _, httpData := range httpDatas {
-		select {
-		case <-httpData.ended:
-		case <-time.After(cp.timeout):
-			atomic.AddInt64(&cp.timeoutNum, 1)
-			httpData.Response = nil
-			httpData.Err = errorRequestCallTimeout
+		if !httpData.coalesced {
+			cp.wait(httpData)
+		}
+	}
+	coalesced.Wait()
+	fallbacks := new(sync.WaitGroup)
+	for _, httpData := range httpDatas {
+		if httpData.Err != nil && isFallbackError(httpData.Err) {
+			fallbacks.Add(1)
+			go func(httpData *HTTPData) {
+				defer fallbacks.Done()
+				cp.runFallback(httpData)
+			}(httpData)
 		}
 	}
+	fallbacks.Wait()
+	for _, httpData := range httpDatas {
+		cp.settle(httpData)
+	}
 }
 
 //Status 获取连接池状态并初始化状态
 func (cp *HTTPConnectionPool) Status() string {
+	cp.lock.Lock()
 	totalPoolNum := cp.poolNum
+	workerNum := cp.workerNum
+	cp.lock.Unlock()
 	poolNum := len(cp.requestPool)
+	waitNum := cp.waitQueue.len()
+	stats := cp.loadStats()
+	cp.lock.Lock()
+	last := cp.lastStats
+	cp.lastStats = stats
+	cp.lock.Unlock()
+	var queueWait, execTime time.Duration
+	if doneNum := stats.doneNum - last.doneNum; doneNum > 0 {
+		queueWait = time.Duration((stats.queueNanos - last.queueNanos) / doneNum)
+		execTime = time.Duration((stats.execNanos - last.execNanos) / doneNum)
+	}
+	hedgeNum := stats.hedgeNum - last.hedgeNum
+	rateLimitedNum := stats.rateLimitedNum - last.rateLimitedNum
+	cacheHitNum := stats.cacheHitNum - last.cacheHitNum
+	coalescedNum := stats.coalescedNum - last.coalescedNum
+	fallbackNum := stats.fallbackNum - last.fallbackNum
+	upstreamStatus := ""
+	if upstream := cp.getUpstream(); upstream != nil {
+		upstreamStatus = fmt.Sprintf(", upstream=[%s]", upstream.Status())
+	}
+	upstreamStatus += cp.partitionStatus()
 	totalNum := cp.totalNum
 	poolFullNum := cp.poolFullNum
 	timeoutNum := cp.timeoutNum
 	atomic.StoreInt64(&cp.totalNum, 0)
 	atomic.StoreInt64(&cp.poolFullNum, 0)
 	atomic.StoreInt64(&cp.timeoutNum, 0)
-	return fmt.Sprintf("HTTPConnectionPool Status: name=%s, totalPoolNum=%d, usedPoolNum=%d, totalNum=%d, poolFullNum=%d, timeoutNum=%d",
-		cp.name, totalPoolNum, poolNum, totalNum, poolFullNum, timeoutNum)
+	return fmt.Sprintf("HTTPConnectionPool Status: name=%s, totalPoolNum=%d, usedPoolNum=%d, totalNum=%d, poolFullNum=%d, timeoutNum=%d, workerNum=%d, waitNum=%d, queueWait=%v, execTime=%v, hedgeNum=%d, rateLimitedNum=%d, cacheHitNum=%d, coalescedNum=%d, fallbackNum=%d%s",
+		cp.GetName(), totalPoolNum, poolNum, totalNum, poolFullNum, timeoutNum, workerNum, waitNum, queueWait, execTime, hedgeNum, rateLimitedNum, cacheHitNum, coalescedNum, fallbackNum, upstreamStatus)
+}
+
+// Close 关闭连接池：不再接受新请求，队列中未执行的请求以连接池关闭错误返回，
+// worker退出并关闭空闲连接。正在执行的请求不会被中断
+func (cp *HTTPConnectionPool) Close() {
+	httpPools.unregister(cp)
+	cp.stopAccepting()
+	cp.stopWorkers()
+	cp.cancelQueued()
+	cp.getClient().CloseIdleConnections()
+}
+
+// Shutdown 优雅关闭连接池：不再接受新请求，等待队列中和正在执行的请求完成后
+// 退出worker并关闭空闲连接。ctx到期时剩余的排队请求以连接池关闭错误返回，并返回ctx.Err()
+func (cp *HTTPConnectionPool) Shutdown(ctx context.Context) error {
+	httpPools.unregister(cp)
+	cp.stopAccepting()
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	var err error
+	for err == nil && atomic.LoadInt64(&cp.pendingNum) > 0 {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+	cp.stopWorkers()
+	cp.cancelQueued()
+	cp.getClient().CloseIdleConnections()
+	return err
+}
+
+func (cp *HTTPConnectionPool) stopAccepting() {
+	cp.closeOnce.Do(func() {
+		close(cp.closing)
+		cp.DisableAdaptive()
+		// 等待持读锁的入队操作完成，之后不会再有请求入队
+		cp.admitLock.Lock()
+		cp.admitLock.Unlock()
+	})
+}
+
+func (cp *HTTPConnectionPool) stopWorkers() {
+	cp.quitOnce.Do(func() {
+		// 持写锁关闭，之后等待队列中的请求不会再入队
+		cp.admitLock.Lock()
+		cp.lock.Lock()
+		close(cp.quit)
+		cp.lock.Unlock()
+		cp.admitLock.Unlock()
+	})
+}
+
+func (cp *HTTPConnectionPool) isClosing() bool {
+	select {
+	case <-cp.closing:
+		return true
+	default:
+		return false
+	}
+}
+
+func (cp *HTTPConnectionPool) isQuit() bool {
+	select {
+	case <-cp.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+// cancelQueued 取出队列中未执行的请求并返回连接池关闭错误
+func (cp *HTTPConnectionPool) cancelQueued() {
+	for {
+		select {
+		case httpData := <-cp.requestPool:
+			atomic.AddInt64(&cp.pendingNum, -1)
+			httpData.releasePartition()
+			httpData.finish(nil, errorRequestPoolClosed)
+		default:
+			return
+		}
+	}
 }
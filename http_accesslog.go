@@ -0,0 +1,194 @@
+package goutils
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultRedactParams 默认隐藏值的query参数
+var DefaultRedactParams = []string{"token", "access_token", "password", "passwd", "secret", "sign", "signature", "key", "api_key", "apikey"}
+
+// redactedValue 被隐藏的参数值
+const redactedValue = "***"
+
+// HTTPAccessEntry 一次出站请求的访问记录
+type HTTPAccessEntry struct {
+	Method    string
+	Host      string
+	Path      string //包含隐藏敏感参数后的query
+	Status    int    //没有响应时为0
+	Bytes     int64  //响应长度，未知时为-1
+	QueueWait time.Duration
+	ExecTime  time.Duration
+	Retries   int   //对冲等额外发出的请求次数
+	Fallback  bool  //是否执行了降级处理
+	Err       error //请求错误，降级时为触发降级的原始错误，其中的URL同样隐藏敏感参数
+}
+
+// String 格式化为不含空格的子记录，便于和ServerContext中其它kv一起解析
+func (e *HTTPAccessEntry) String() string {
+	errStr := ""
+	if e.Err != nil {
+		errStr = e.Err.Error()
+	}
+	return fmt.Sprintf("{method=%s,host=%s,path=%s,status=%d,bytes=%d,queue=%v,exec=%v,retries=%d,fallback=%v,err=%s}",
+		e.Method, e.Host, e.Path, e.Status, e.Bytes, e.QueueWait, e.ExecTime, e.Retries, e.Fallback, errStr)
+}
+
+// HTTPAccessLog 出站请求访问日志配置，请求结束后把访问记录写入请求的ServerContext
+type HTTPAccessLog struct {
+	Key          string                                          //写入ServerContext的key，默认http_access
+	RedactParams []string                                        //需要隐藏值的query参数，不区分大小写，nil时使用DefaultRedactParams
+	Handler      func(sc *ServerContext, entry *HTTPAccessEntry) //自定义记录方式，例如生成子span，nil时调用AddNotes
+}
+
+type httpAccessLog struct {
+	config HTTPAccessLog
+	redact map[string]bool
+}
+
+// SetAccessLog 设置出站请求访问日志，nil表示关闭
+func (cp *HTTPConnectionPool) SetAccessLog(accessLog *HTTPAccessLog) {
+	var a *httpAccessLog
+	if accessLog != nil {
+		a = &httpAccessLog{config: *accessLog, redact: make(map[string]bool)}
+		if a.config.Key == "" {
+			a.config.Key = "http_access"
+		}
+		params := a.config.RedactParams
+		if params == nil {
+			params = DefaultRedactParams
+		}
+		for _, param := range params {
+			a.redact[strings.ToLower(param)] = true
+		}
+	}
+	cp.lock.Lock()
+	cp.accessLog = a
+	cp.lock.Unlock()
+}
+
+func (cp *HTTPConnectionPool) getAccessLog() *httpAccessLog {
+	cp.lock.Lock()
+	defer cp.lock.Unlock()
+	return cp.accessLog
+}
+
+// logAccess 请求结束后写访问记录，HTTPData没有指定ServerContext时从请求的context中获取
+func (cp *HTTPConnectionPool) logAccess(httpData *HTTPData) {
+	a := cp.getAccessLog()
+	if a == nil || httpData.Request == nil {
+		return
+	}
+	sc := httpData.serverContext()
+	if sc == nil {
+		return
+	}
+	entry := a.entry(httpData)
+	if a.config.Handler != nil {
+		a.config.Handler(sc, entry)
+		return
+	}
+	sc.AddNotes(a.config.Key, entry.String())
+}
+
+// serverContext 记录请求日志的ServerContext，优先使用HTTPData指定的，其次从请求的context中获取
+func (d *HTTPData) serverContext() *ServerContext {
+	if d.ServerContext != nil {
+		return d.ServerContext
+	}
+	if d.Request == nil {
+		return nil
+	}
+	return ServerContextFrom(d.Request.Context())
+}
+
+func (a *httpAccessLog) entry(httpData *HTTPData) *HTTPAccessEntry {
+	request := httpData.Request
+	entry := &HTTPAccessEntry{
+		Method:    request.Method,
+		Host:      request.URL.Host,
+		Path:      a.redactPath(request.URL),
+		Bytes:     -1,
+		QueueWait: httpData.QueueWait,
+		ExecTime:  httpData.ExecTime,
+		Retries:   httpData.retries,
+		Fallback:  httpData.FallbackFrom != nil,
+		Err:       redactURLError(httpData.Err, a.redactURL),
+	}
+	if entry.Fallback {
+		entry.Err = redactURLError(httpData.FallbackFrom, a.redactURL)
+	}
+	if response := httpData.Response; response != nil {
+		entry.Status = response.StatusCode
+		entry.Bytes = response.ContentLength
+	}
+	return entry
+}
+
+func (a *httpAccessLog) redactURL(u *url.URL) string {
+	return u.Scheme + "://" + u.Host + a.redactPath(u)
+}
+
+// redactPath 返回path和query，需要隐藏的参数值替换为***，参数顺序不变
+func (a *httpAccessLog) redactPath(u *url.URL) string {
+	path := u.EscapedPath()
+	if u.RawQuery == "" {
+		return path
+	}
+	params := strings.Split(u.RawQuery, "&")
+	for i, param := range params {
+		rawName := param
+		if n := strings.IndexByte(param, '='); n >= 0 {
+			rawName = param[:n]
+		}
+		name, err := url.QueryUnescape(rawName)
+		if err != nil {
+			name = rawName
+		}
+		if a.redact[strings.ToLower(name)] {
+			params[i] = rawName + "=" + redactedValue
+		}
+	}
+	return path + "?" + strings.Join(params, "&")
+}
+
+// redactedError 替换了错误信息中URL的错误，Unwrap返回原始错误
+type redactedError struct {
+	msg string
+	err error
+}
+
+func (e *redactedError) Error() string {
+	return e.msg
+}
+
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
+
+// redactURLError 把错误中*url.Error的URL替换为redactURL的结果，没有*url.Error时原样返回
+func redactURLError(err error, redactURL func(u *url.URL) string) error {
+	var ue *url.Error
+	if !errors.As(err, &ue) {
+		return err
+	}
+	redacted := ue.URL
+	if u, parseErr := url.Parse(ue.URL); parseErr == nil {
+		redacted = redactURL(u)
+	} else if n := strings.IndexByte(redacted, '?'); n >= 0 {
+		redacted = redacted[:n]
+	}
+	if err == ue {
+		return &url.Error{Op: ue.Op, URL: redacted, Err: ue.Err}
+	}
+	return &redactedError{msg: strings.ReplaceAll(err.Error(), ue.URL, redacted), err: err}
+}
+
+// stripQuery 去掉query的URL，用于不记录参数的错误信息
+func stripQuery(u *url.URL) string {
+	return u.Scheme + "://" + u.Host + u.EscapedPath()
+}
@@ -0,0 +1,91 @@
+package goutils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_HTTPAccessLog(t *testing.T) {
+	upstream := NewMockUpstream()
+	defer upstream.Close()
+	upstream.Script("/users", MockResponse{Status: http.StatusCreated, Body: "hello"})
+	pool := NewHTTPConnectionPoolWithOptions(WithTimeout(time.Second), WithPoolNum(2), WithBufferedResponse(1024), WithAccessLog(HTTPAccessLog{}))
+	defer pool.Close()
+
+	sc := NewContext("test")
+	httpData := NewHTTPData(newGetRequest(upstream.URL + "/users?id=1&Token=abc&sign=xyz"))
+	httpData.ServerContext = sc
+	if err := pool.Do(httpData); err != nil {
+		t.Fatal(err.Error())
+	}
+	CloseResponse(httpData.Response)
+	notes := sc.buf.String()
+	for _, want := range []string{" http_access={method=GET,host=" + upstream.Addr(), "path=/users?id=1&Token=***&sign=***,", "status=201,", "bytes=5,", "retries=0,", "err=}"} {
+		if !strings.Contains(notes, want) {
+			t.Errorf("access log %q missing %q", notes, want)
+		}
+	}
+	if strings.Contains(notes, "abc") || strings.Contains(notes, "xyz") {
+		t.Errorf("access log leaked secret: %q", notes)
+	}
+}
+
+func Test_HTTPAccessLogTransportError(t *testing.T) {
+	pool := NewHTTPConnectionPoolWithOptions(WithTimeout(time.Second), WithPoolNum(2), WithAccessLog(HTTPAccessLog{}))
+	defer pool.Close()
+	sc := NewContext("test")
+	httpData := NewHTTPData(newGetRequest("http://127.0.0.1:1/p?id=1&token=SECRET"))
+	httpData.ServerContext = sc
+	if err := pool.Do(httpData); err == nil {
+		t.Fatal("request should fail")
+	}
+	notes := sc.buf.String()
+	if strings.Contains(notes, "SECRET") || !strings.Contains(notes, `err=Get "http://127.0.0.1:1/p?id=1&token=***"`) {
+		t.Errorf("access log %q", notes)
+	}
+	wrapped := fmt.Errorf("retry: %w", httpData.Err)
+	redacted := redactURLError(wrapped, func(u *url.URL) string { return u.Path })
+	if strings.Contains(redacted.Error(), "SECRET") || !errors.Is(redacted, httpData.Err) {
+		t.Errorf("redacted:%v", redacted)
+	}
+}
+
+func Test_HTTPAccessLogContext(t *testing.T) {
+	var entries []*HTTPAccessEntry
+	pool := NewHTTPConnectionPoolWithOptions(WithTimeout(time.Second), WithPoolNum(2), WithAccessLog(HTTPAccessLog{
+		RedactParams: []string{"q"},
+		Handler: func(sc *ServerContext, entry *HTTPAccessEntry) {
+			entries = append(entries, entry)
+		},
+	}))
+	defer pool.Close()
+	pool.Use(func(next RoundTripFunc) RoundTripFunc {
+		return func(request *http.Request) (*http.Response, error) {
+			return nil, errors.New("refused")
+		}
+	})
+
+	sc := NewContext("test")
+	request := newGetRequest("http://example.com/search?q=secret&token=abc")
+	request = request.WithContext(WithServerContext(context.Background(), sc))
+	pool.BatchRequest([]*HTTPData{NewHTTPData(request)})
+	if len(entries) != 1 {
+		t.Fatalf("expected one entry, got %d", len(entries))
+	}
+	entry := entries[0]
+	if entry.Path != "/search?q=***&token=abc" || entry.Status != 0 || entry.Bytes != -1 || entry.Err == nil {
+		t.Errorf("unexpected entry %s", entry)
+	}
+
+	// 没有ServerContext时不记录
+	pool.Request(newGetRequest("http://example.com/"))
+	if len(entries) != 1 {
+		t.Error("request without ServerContext should not be logged")
+	}
+}
@@ -0,0 +1,132 @@
+package goutils
+
+import (
+	"math"
+	"time"
+)
+
+var (
+	defaultAdaptiveInterval = 10 * time.Second
+	defaultAdaptiveHeadroom = 1.5
+)
+
+// HTTPAdaptiveConfig 连接池自适应配置
+// 每个周期按Little's law(并发数 = 吞吐 * 平均耗时)估算所需worker数，
+// 并用AIMD限制上限：出现连接池满时加性增加，出现超时时乘性减少
+type HTTPAdaptiveConfig struct {
+	MinPoolNum int           //最小worker数
+	MaxPoolNum int           //最大worker数
+	Interval   time.Duration //调整周期，默认10s
+	Headroom   float64       //估算并发数的放大系数，默认1.5
+	Step       int           //加性增加的步长，默认1
+}
+
+type httpAdaptive struct {
+	config HTTPAdaptiveConfig
+	limit  int //AIMD计算出的worker上限
+	last   httpPoolStats
+	stop   chan bool
+}
+
+// EnableAdaptive 开启自适应模式，按观测到的耗时和并发周期性调整worker数目
+// 重复调用会用新配置替换旧配置，连接池关闭后调用无效
+func (cp *HTTPConnectionPool) EnableAdaptive(config HTTPAdaptiveConfig) {
+	if config.MinPoolNum <= 0 {
+		config.MinPoolNum = 1
+	}
+	if config.MaxPoolNum < config.MinPoolNum {
+		config.MaxPoolNum = config.MinPoolNum
+	}
+	if config.Interval <= 0 {
+		config.Interval = defaultAdaptiveInterval
+	}
+	if config.Headroom <= 0 {
+		config.Headroom = defaultAdaptiveHeadroom
+	}
+	if config.Step <= 0 {
+		config.Step = 1
+	}
+	adaptive := &httpAdaptive{
+		config: config,
+		limit:  config.MaxPoolNum,
+		last:   cp.loadStats(),
+		stop:   make(chan bool),
+	}
+	cp.lock.Lock()
+	if cp.isClosing() {
+		// 关闭时先close(closing)再DisableAdaptive，这里检查后不会留下没人停止的goroutine
+		cp.lock.Unlock()
+		return
+	}
+	old := cp.adaptive
+	cp.adaptive = adaptive
+	cp.lock.Unlock()
+	if old != nil {
+		close(old.stop)
+	}
+	go cp.runAdaptive(adaptive)
+}
+
+// DisableAdaptive 关闭自适应模式，worker数目保持当前值
+func (cp *HTTPConnectionPool) DisableAdaptive() {
+	cp.lock.Lock()
+	old := cp.adaptive
+	cp.adaptive = nil
+	cp.lock.Unlock()
+	if old != nil {
+		close(old.stop)
+	}
+}
+
+func (cp *HTTPConnectionPool) runAdaptive(adaptive *httpAdaptive) {
+	ticker := time.NewTicker(adaptive.config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			cp.SetPoolNum(adaptive.adjust(cp.loadStats(), cp.GetPoolNum()))
+		case <-adaptive.stop:
+			return
+		}
+	}
+}
+
+// adjust 根据本周期的统计计算新的worker数目
+func (a *httpAdaptive) adjust(stats httpPoolStats, poolNum int) int {
+	doneNum := stats.doneNum - a.last.doneNum
+	execNanos := stats.execNanos - a.last.execNanos
+	poolFullNum := stats.poolFullNum - a.last.poolFullNum
+	timeoutNum := stats.timeoutNum - a.last.timeoutNum
+	a.last = stats
+
+	if timeoutNum > 0 {
+		a.limit = poolNum / 2
+	} else if poolFullNum > 0 {
+		a.limit = poolNum + a.config.Step
+	}
+	a.limit = a.clamp(a.limit)
+
+	target := poolNum
+	if doneNum > 0 {
+		throughput := float64(doneNum) / a.config.Interval.Seconds()
+		latency := time.Duration(execNanos / doneNum).Seconds()
+		target = int(math.Ceil(throughput * latency * a.config.Headroom))
+	}
+	if poolFullNum > 0 && target <= poolNum {
+		target = poolNum + a.config.Step
+	}
+	if target > a.limit {
+		target = a.limit
+	}
+	return a.clamp(target)
+}
+
+func (a *httpAdaptive) clamp(poolNum int) int {
+	if poolNum < a.config.MinPoolNum {
+		return a.config.MinPoolNum
+	}
+	if poolNum > a.config.MaxPoolNum {
+		return a.config.MaxPoolNum
+	}
+	return poolNum
+}
@@ -0,0 +1,59 @@
+package goutils
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_HTTPAdaptiveLittleLaw(t *testing.T) {
+	adaptive := &httpAdaptive{
+		config: HTTPAdaptiveConfig{MinPoolNum: 1, MaxPoolNum: 100, Interval: time.Second, Headroom: 1, Step: 1},
+		limit:  100,
+	}
+	// 100 qps * 200ms = 20
+	stats := httpPoolStats{doneNum: 100, execNanos: int64(100 * 200 * time.Millisecond)}
+	if num := adaptive.adjust(stats, 10); num != 20 {
+		t.Errorf("pool num:%d", num)
+	}
+}
+
+func Test_HTTPAdaptiveAIMD(t *testing.T) {
+	adaptive := &httpAdaptive{
+		config: HTTPAdaptiveConfig{MinPoolNum: 2, MaxPoolNum: 100, Interval: time.Second, Headroom: 1, Step: 2},
+		limit:  100,
+	}
+	stats := httpPoolStats{poolFullNum: 5}
+	if num := adaptive.adjust(stats, 10); num != 12 {
+		t.Errorf("pool num:%d", num)
+	}
+	stats.timeoutNum = 1
+	if num := adaptive.adjust(stats, 12); num != 6 {
+		t.Errorf("pool num:%d", num)
+	}
+	stats.timeoutNum = 2
+	if num := adaptive.adjust(stats, 3); num != 2 {
+		t.Errorf("pool num:%d", num)
+	}
+}
+
+func Test_HTTPEnableAdaptive(t *testing.T) {
+	pool := NewHTTPConnectionPool(time.Second, 10)
+	pool.EnableAdaptive(HTTPAdaptiveConfig{MinPoolNum: 3, MaxPoolNum: 5, Interval: 10 * time.Millisecond})
+	time.Sleep(50 * time.Millisecond)
+	pool.DisableAdaptive()
+	if num := pool.GetPoolNum(); num < 3 || num > 5 {
+		t.Errorf("pool num:%d", num)
+	}
+}
+
+func Test_HTTPEnableAdaptiveAfterClose(t *testing.T) {
+	pool := NewHTTPConnectionPool(time.Second, 10)
+	pool.Close()
+	pool.EnableAdaptive(HTTPAdaptiveConfig{MinPoolNum: 1, MaxPoolNum: 10, Interval: 10 * time.Millisecond})
+	pool.lock.Lock()
+	adaptive := pool.adaptive
+	pool.lock.Unlock()
+	if adaptive != nil {
+		t.Error("adaptive should not start on a closed pool")
+	}
+}
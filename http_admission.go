@@ -0,0 +1,234 @@
+package goutils
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	defaultCoDelTarget   = 10 * time.Millisecond
+	defaultCoDelInterval = 100 * time.Millisecond
+	defaultShedRatio     = 0.8
+)
+
+// HTTPAdmissionPolicy 连接池满时的准入策略
+type HTTPAdmissionPolicy int
+
+const (
+	// AdmissionReject 立即拒绝，返回连接池满错误
+	AdmissionReject HTTPAdmissionPolicy = iota
+	// AdmissionWait 进入等待队列先到先服务，最多等待Wait时间
+	AdmissionWait
+	// AdmissionLIFO 进入等待队列后到先服务，积压时优先服务还没放弃的新请求
+	AdmissionLIFO
+	// AdmissionCoDel 先到先服务，排队时间持续Interval超过Target时丢弃排队过久的请求
+	AdmissionCoDel
+)
+
+// HTTPAdmission 准入策略配置
+type HTTPAdmission struct {
+	Policy    HTTPAdmissionPolicy
+	Wait      time.Duration //最长等待时间，默认为连接池超时时间
+	QueueSize int           //等待队列长度，0表示不限制
+	Target    time.Duration //CoDel目标排队时间，默认10ms
+	Interval  time.Duration //CoDel观察周期，默认100ms
+	ShedRatio float64       //队列使用率达到该比例时丢弃BestEffort请求，默认0.8
+}
+
+type httpWaiter struct {
+	httpData *HTTPData
+	element  *list.Element
+	result   chan error
+}
+
+// httpWaitQueue 连接池满时的等待队列
+type httpWaitQueue struct {
+	lock       *sync.Mutex
+	waiters    *list.List
+	firstAbove time.Time //CoDel排队时间超过target后的判定时间点
+}
+
+func newHTTPWaitQueue() *httpWaitQueue {
+	return &httpWaitQueue{lock: new(sync.Mutex), waiters: list.New()}
+}
+
+func (q *httpWaitQueue) len() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.waiters.Len()
+}
+
+// push 加入等待队列，队列满时挤掉最后加入的低优先级请求，没有可挤掉的请求时返回false
+func (q *httpWaitQueue) push(waiter *httpWaiter, queueSize int) bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if queueSize > 0 && q.waiters.Len() >= queueSize {
+		var victim *httpWaiter
+		for element := q.waiters.Back(); element != nil; element = element.Prev() {
+			w := element.Value.(*httpWaiter)
+			if priorityRank(w.httpData.Priority) > priorityRank(waiter.httpData.Priority) {
+				victim = w
+				break
+			}
+		}
+		if victim == nil {
+			return false
+		}
+		q.removeLocked(victim)
+		victim.result <- errorRequestShed
+	}
+	waiter.element = q.waiters.PushBack(waiter)
+	return true
+}
+
+// next 按优先级选出下一个准入的请求，同优先级内按先到先服务或后到先服务
+func (q *httpWaitQueue) next(lifo bool) *httpWaiter {
+	var best *httpWaiter
+	element := q.waiters.Front()
+	if lifo {
+		element = q.waiters.Back()
+	}
+	for element != nil {
+		w := element.Value.(*httpWaiter)
+		if best == nil || priorityRank(w.httpData.Priority) < priorityRank(best.httpData.Priority) {
+			best = w
+		}
+		if lifo {
+			element = element.Prev()
+		} else {
+			element = element.Next()
+		}
+	}
+	return best
+}
+
+// priorityRank 数值越小越优先
+func priorityRank(priority HTTPPriority) int {
+	switch priority {
+	case PriorityCritical:
+		return 0
+	case PriorityBestEffort:
+		return 2
+	default:
+		return 1
+	}
+}
+
+// remove 从队列中移除，已经被移除(准入或丢弃)时返回false
+func (q *httpWaitQueue) remove(waiter *httpWaiter) bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.removeLocked(waiter)
+}
+
+func (q *httpWaitQueue) removeLocked(waiter *httpWaiter) bool {
+	if waiter.element == nil {
+		return false
+	}
+	q.waiters.Remove(waiter.element)
+	waiter.element = nil
+	return true
+}
+
+// codelDrop CoDel判定，排队时间持续一个周期超过target时丢弃
+func (q *httpWaitQueue) codelDrop(sojourn time.Duration, now time.Time, admission HTTPAdmission) bool {
+	if sojourn < admission.Target {
+		q.firstAbove = time.Time{}
+		return false
+	}
+	if q.firstAbove.IsZero() {
+		q.firstAbove = now.Add(admission.Interval)
+		return false
+	}
+	return !now.Before(q.firstAbove)
+}
+
+// SetAdmission 运行时设置准入策略，已经在等待的请求不受影响
+func (cp *HTTPConnectionPool) SetAdmission(admission HTTPAdmission) {
+	cp.lock.Lock()
+	cp.admission = admission
+	cp.lock.Unlock()
+}
+
+// GetAdmission 获取当前准入策略，未设置的参数填充为默认值
+func (cp *HTTPConnectionPool) GetAdmission() HTTPAdmission {
+	cp.lock.Lock()
+	admission := cp.admission
+	timeout := cp.timeout
+	cp.lock.Unlock()
+	if admission.Wait <= 0 {
+		admission.Wait = timeout
+	}
+	if admission.Target <= 0 {
+		admission.Target = defaultCoDelTarget
+	}
+	if admission.Interval <= 0 {
+		admission.Interval = defaultCoDelInterval
+	}
+	if admission.ShedRatio <= 0 {
+		admission.ShedRatio = defaultShedRatio
+	}
+	return admission
+}
+
+// waitAdmission 进入等待队列，直到被worker准入、等待超时、被高优先级请求挤掉或者worker退出。
+// 等待中的请求计入pendingNum，优雅关闭时会等待它们被准入并执行完成
+func (cp *HTTPConnectionPool) waitAdmission(httpData *HTTPData, admission HTTPAdmission) error {
+	if cp.isClosing() {
+		return errorRequestPoolClosed
+	}
+	wait := admission.Wait
+	if remain := time.Until(httpData.deadline); remain < wait {
+		wait = remain
+	}
+	waiter := &httpWaiter{httpData: httpData, result: make(chan error, 1)}
+	if !cp.waitQueue.push(waiter, admission.QueueSize) {
+		cp.addPoolFull()
+		return errorRequestPoolFull
+	}
+	atomic.AddInt64(&cp.pendingNum, 1)
+	defer atomic.AddInt64(&cp.pendingNum, -1)
+	// 入队前可能刚好有worker空闲下来，主动尝试一次
+	cp.admitWaiting()
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case err := <-waiter.result:
+		return err
+	case <-timer.C:
+		if cp.waitQueue.remove(waiter) {
+			cp.addPoolFull()
+			return errorRequestQueueTimeout
+		}
+	case <-cp.quit:
+		if cp.waitQueue.remove(waiter) {
+			return errorRequestPoolClosed
+		}
+	}
+	return <-waiter.result
+}
+
+// admitWaiting 按准入策略把等待队列中的请求放入连接池，直到连接池满
+func (cp *HTTPConnectionPool) admitWaiting() {
+	admission := cp.GetAdmission()
+	q := cp.waitQueue
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	for q.waiters.Len() > 0 {
+		waiter := q.next(admission.Policy == AdmissionLIFO)
+		now := time.Now()
+		if admission.Policy == AdmissionCoDel && q.codelDrop(now.Sub(waiter.httpData.enqueued), now, admission) {
+			q.removeLocked(waiter)
+			cp.addPoolFull()
+			waiter.result <- errorRequestQueueTimeout
+			continue
+		}
+		if err := cp.admitEnqueue(waiter.httpData); err != nil {
+			return
+		}
+		q.removeLocked(waiter)
+		waiter.result <- nil
+	}
+}
@@ -0,0 +1,215 @@
+package goutils
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newSlowServer(delay time.Duration) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+		w.Write([]byte(r.URL.Path))
+	}))
+}
+
+func concurrentRequest(pool *HTTPConnectionPool, url string, num int) []error {
+	errs := make([]error, num)
+	wg := new(sync.WaitGroup)
+	for i := 0; i < num; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			request, _ := http.NewRequest("GET", url, nil)
+			response, err := pool.Request(request)
+			if err == nil {
+				response.Body.Close()
+			}
+			errs[i] = err
+		}(i)
+		time.Sleep(5 * time.Millisecond)
+	}
+	wg.Wait()
+	return errs
+}
+
+func Test_HTTPAdmissionReject(t *testing.T) {
+	server := newSlowServer(50 * time.Millisecond)
+	defer server.Close()
+	pool := NewHTTPConnectionPool(time.Second, 1)
+	defer pool.Close()
+	time.Sleep(20 * time.Millisecond)
+	errs := concurrentRequest(pool, server.URL, 3)
+	if errs[2] != errorRequestPoolFull {
+		t.Errorf("request err:%v", errs[2])
+	}
+}
+
+func Test_HTTPAdmissionWait(t *testing.T) {
+	server := newSlowServer(50 * time.Millisecond)
+	defer server.Close()
+	pool := NewHTTPConnectionPoolWithOptions(WithTimeout(time.Second), WithPoolNum(1),
+		WithAdmission(HTTPAdmission{Policy: AdmissionWait, Wait: time.Second}))
+	defer pool.Close()
+	time.Sleep(20 * time.Millisecond)
+	for _, err := range concurrentRequest(pool, server.URL, 4) {
+		if err != nil {
+			t.Errorf("request err:%s", err.Error())
+		}
+	}
+	t.Log(pool.Status())
+}
+
+func Test_HTTPAdmissionShutdownDrainsWaiters(t *testing.T) {
+	server := newSlowServer(50 * time.Millisecond)
+	defer server.Close()
+	for _, policy := range []HTTPAdmissionPolicy{AdmissionWait, AdmissionLIFO, AdmissionCoDel} {
+		pool := NewHTTPConnectionPoolWithOptions(WithTimeout(time.Second), WithPoolNum(1),
+			WithAdmission(HTTPAdmission{Policy: policy, Wait: time.Second, Target: time.Second}))
+		time.Sleep(20 * time.Millisecond)
+		var errs []error
+		done := make(chan bool)
+		go func() {
+			errs = concurrentRequest(pool, server.URL, 4)
+			done <- true
+		}()
+		// 第3、4个请求在等待队列中
+		time.Sleep(30 * time.Millisecond)
+		if waitNum := pool.waitQueue.len(); waitNum == 0 {
+			t.Errorf("policy %d: no waiting request", policy)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		if err := pool.Shutdown(ctx); err != nil {
+			t.Errorf("policy %d: shutdown err:%s", policy, err.Error())
+		}
+		cancel()
+		<-done
+		for i, err := range errs {
+			if err != nil {
+				t.Errorf("policy %d: request %d err:%s", policy, i, err.Error())
+			}
+		}
+	}
+}
+
+func Test_HTTPAdmissionWaitTimeout(t *testing.T) {
+	server := newSlowServer(100 * time.Millisecond)
+	defer server.Close()
+	pool := NewHTTPConnectionPoolWithOptions(WithTimeout(time.Second), WithPoolNum(1),
+		WithAdmission(HTTPAdmission{Policy: AdmissionWait, Wait: 20 * time.Millisecond}))
+	defer pool.Close()
+	time.Sleep(20 * time.Millisecond)
+	errs := concurrentRequest(pool, server.URL, 3)
+	if errs[2] != errorRequestQueueTimeout {
+		t.Errorf("request err:%v", errs[2])
+	}
+}
+
+func Test_HTTPAdmissionQueueSize(t *testing.T) {
+	server := newSlowServer(100 * time.Millisecond)
+	defer server.Close()
+	pool := NewHTTPConnectionPoolWithOptions(WithTimeout(time.Second), WithPoolNum(1),
+		WithAdmission(HTTPAdmission{Policy: AdmissionWait, QueueSize: 1}))
+	defer pool.Close()
+	time.Sleep(20 * time.Millisecond)
+	errs := concurrentRequest(pool, server.URL, 4)
+	if errs[2] != nil || errs[3] != errorRequestPoolFull {
+		t.Errorf("request err:%v %v", errs[2], errs[3])
+	}
+}
+
+func Test_HTTPAdmissionLIFO(t *testing.T) {
+	pool := NewHTTPConnectionPoolWithOptions(WithTimeout(time.Second), WithPoolNum(0),
+		WithAdmission(HTTPAdmission{Policy: AdmissionLIFO}))
+	defer pool.Close()
+	first, second := NewHTTPData(nil), NewHTTPData(nil)
+	pool.waitQueue.push(&httpWaiter{httpData: first, result: make(chan error, 1)}, 0)
+	last := &httpWaiter{httpData: second, result: make(chan error, 1)}
+	pool.waitQueue.push(last, 0)
+	received := make(chan *HTTPData)
+	go func() {
+		received <- <-pool.requestPool
+	}()
+	for {
+		pool.admitWaiting()
+		select {
+		case httpData := <-received:
+			if httpData != second || pool.waitQueue.len() != 1 {
+				t.Fail()
+			}
+			if err := <-last.result; err != nil {
+				t.Error(err.Error())
+			}
+			return
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
+
+func Test_HTTPAdmissionCoDel(t *testing.T) {
+	queue := newHTTPWaitQueue()
+	admission := HTTPAdmission{Target: 10 * time.Millisecond, Interval: 100 * time.Millisecond}
+	now := time.Now()
+	if queue.codelDrop(5*time.Millisecond, now, admission) {
+		t.Fail()
+	}
+	if queue.codelDrop(20*time.Millisecond, now, admission) {
+		t.Fail()
+	}
+	if queue.codelDrop(20*time.Millisecond, now.Add(50*time.Millisecond), admission) {
+		t.Fail()
+	}
+	if !queue.codelDrop(20*time.Millisecond, now.Add(100*time.Millisecond), admission) {
+		t.Fail()
+	}
+	if queue.codelDrop(5*time.Millisecond, now.Add(110*time.Millisecond), admission) {
+		t.Fail()
+	}
+}
+
+func Test_HTTPQueueWait(t *testing.T) {
+	server := newSlowServer(30 * time.Millisecond)
+	defer server.Close()
+	pool := NewHTTPConnectionPool(time.Second, 1)
+	defer pool.Close()
+	time.Sleep(20 * time.Millisecond)
+	httpdatas := make([]*HTTPData, 0, 2)
+	for i := 0; i < 2; i++ {
+		request, _ := http.NewRequest("GET", server.URL, nil)
+		httpdatas = append(httpdatas, NewHTTPData(request))
+	}
+	pool.BatchRequest(httpdatas)
+	if httpdatas[1].QueueWait < 20*time.Millisecond || httpdatas[1].ExecTime < 20*time.Millisecond {
+		t.Errorf("queue wait:%v exec time:%v", httpdatas[1].QueueWait, httpdatas[1].ExecTime)
+	}
+	t.Log(pool.Status())
+}
+
+func Test_HTTPAdmissionPriorityEvict(t *testing.T) {
+	queue := newHTTPWaitQueue()
+	normal := &httpWaiter{httpData: NewHTTPData(nil), result: make(chan error, 1)}
+	critical := &httpWaiter{httpData: NewHTTPData(nil), result: make(chan error, 1)}
+	critical.httpData.Priority = PriorityCritical
+	if !queue.push(normal, 1) {
+		t.Fail()
+	}
+	if queue.push(&httpWaiter{httpData: NewHTTPData(nil), result: make(chan error, 1)}, 1) {
+		t.Fail()
+	}
+	if !queue.push(critical, 1) {
+		t.Fail()
+	}
+	if err := <-normal.result; err != errorRequestShed {
+		t.Errorf("err:%v", err)
+	}
+	if queue.next(false) != critical {
+		t.Fail()
+	}
+}
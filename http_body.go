@@ -0,0 +1,48 @@
+package goutils
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+)
+
+// 关闭body前最多读取的字节数，读完的连接可以被复用
+var drainBodySize int64 = 4096
+
+// CloseResponse 读取剩余的少量body后关闭，保证连接可以复用，response为nil时什么也不做
+func CloseResponse(response *http.Response) {
+	if response == nil || response.Body == nil {
+		return
+	}
+	io.Copy(io.Discard, io.LimitReader(response.Body, drainBodySize))
+	response.Body.Close()
+}
+
+// bufferResponse 把body完整读到内存中并关闭原始body，超过maxSize时返回错误
+func bufferResponse(response *http.Response, maxSize int64) (*http.Response, error) {
+	defer response.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(response.Body, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxSize {
+		return nil, errorResponseTooLarge
+	}
+	response.Body = io.NopCloser(bytes.NewReader(data))
+	response.ContentLength = int64(len(data))
+	return response, nil
+}
+
+// SetBufferedResponse 开启后worker把响应body完整读到内存中再返回，读取body的时间计入请求超时，
+// 超过maxSize时返回错误，maxSize小于等于0表示关闭
+func (cp *HTTPConnectionPool) SetBufferedResponse(maxSize int64) {
+	cp.lock.Lock()
+	cp.bufferSize = maxSize
+	cp.lock.Unlock()
+}
+
+func (cp *HTTPConnectionPool) getBufferSize() int64 {
+	cp.lock.Lock()
+	defer cp.lock.Unlock()
+	return cp.bufferSize
+}
@@ -0,0 +1,83 @@
+package goutils
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type trackedBody struct {
+	io.Reader
+	closed *int32
+}
+
+func (b trackedBody) Close() error {
+	atomic.StoreInt32(b.closed, 1)
+	return nil
+}
+
+// slowTransport 延迟返回响应，记录body是否被关闭
+type slowTransport struct {
+	delay  time.Duration
+	body   string
+	closed int32
+}
+
+func (t *slowTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	time.Sleep(t.delay)
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       trackedBody{Reader: strings.NewReader(t.body), closed: &t.closed},
+		Request:    request,
+	}, nil
+}
+
+func Test_HTTPAbandonedBodyClosed(t *testing.T) {
+	transport := &slowTransport{delay: 50 * time.Millisecond, body: "abandoned"}
+	pool := NewHTTPConnectionPoolWithOptions(WithTimeout(10*time.Millisecond), WithPoolNum(1), WithTransport(transport))
+	defer pool.Close()
+	request, _ := http.NewRequest("GET", "http://service/", nil)
+	if _, err := pool.Request(request); err != errorRequestCallTimeout {
+		t.Errorf("err:%v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if atomic.LoadInt32(&transport.closed) != 1 {
+		t.Fail()
+	}
+}
+
+func Test_HTTPBufferedResponse(t *testing.T) {
+	transport := &slowTransport{body: "buffered"}
+	pool := NewHTTPConnectionPoolWithOptions(WithTimeout(time.Second), WithPoolNum(1), WithTransport(transport),
+		WithBufferedResponse(1024))
+	defer pool.Close()
+	request, _ := http.NewRequest("GET", "http://service/", nil)
+	response, err := pool.Request(request)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if atomic.LoadInt32(&transport.closed) != 1 {
+		t.Fail()
+	}
+	body, _ := io.ReadAll(response.Body)
+	if string(body) != "buffered" || response.ContentLength != 8 {
+		t.Errorf("body:%s", body)
+	}
+
+	pool.SetBufferedResponse(4)
+	if _, err := pool.Request(request); err != errorResponseTooLarge {
+		t.Errorf("err:%v", err)
+	}
+}
+
+func Test_CloseResponse(t *testing.T) {
+	var closed int32
+	CloseResponse(nil)
+	CloseResponse(&http.Response{Body: trackedBody{Reader: strings.NewReader("body"), closed: &closed}})
+	if closed != 1 {
+		t.Fail()
+	}
+}
@@ -0,0 +1,223 @@
+package goutils
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var errorBulkheadFull = errors.New("ERROR_HTTP_BULKHEAD_FULL")
+
+// HTTPBulkhead 分区配置，分区内排队和执行中的请求数不超过MaxConcurrent，
+// 超过时最多QueueSize个请求等待，等待到请求的截止时间
+type HTTPBulkhead struct {
+	Name          string //分区名
+	MaxConcurrent int    //分区内同时执行的请求数上限，0表示不限制
+	QueueSize     int    //等待分区空闲的请求数上限，0表示不等待直接拒绝
+}
+
+// HTTPPartitioner 按请求选择分区，返回空字符串表示不属于任何分区
+type HTTPPartitioner func(request *http.Request) string
+
+// HTTPBulkheads 分区隔离配置，一个分区饱和时只拒绝该分区的请求，各分区共用连接池的worker和transport
+type HTTPBulkheads struct {
+	Partitioner HTTPPartitioner //分区函数，默认按host分区
+	Partitions  []HTTPBulkhead  //单独配置的分区
+	Default     HTTPBulkhead    //没有单独配置的分区使用的配置
+}
+
+// HTTPPartitionStats 分区统计
+type HTTPPartitionStats struct {
+	Name          string `json:"name"`
+	MaxConcurrent int    `json:"max_concurrent"`
+	Active        int    `json:"active"`   //排队和执行中的请求数
+	Waiting       int64  `json:"waiting"`  //等待分区空闲的请求数
+	TotalNum      int64  `json:"total"`    //进入分区的请求数
+	RejectNum     int64  `json:"rejected"` //被分区拒绝的请求数
+}
+
+// PartitionByHost 按host分区
+func PartitionByHost() HTTPPartitioner {
+	return func(request *http.Request) string {
+		return request.URL.Host
+	}
+}
+
+// PartitionByPathPrefix 按path前缀分区，分区名为匹配的最长前缀，没有匹配时不分区
+func PartitionByPathPrefix(prefixes ...string) HTTPPartitioner {
+	sorted := append([]string(nil), prefixes...)
+	sort.Slice(sorted, func(i, j int) bool { return len(sorted[i]) > len(sorted[j]) })
+	return func(request *http.Request) string {
+		for _, prefix := range sorted {
+			if strings.HasPrefix(request.URL.Path, prefix) {
+				return prefix
+			}
+		}
+		return ""
+	}
+}
+
+type httpPartition struct {
+	config    HTTPBulkhead
+	slots     chan bool
+	waiting   int64
+	totalNum  int64
+	rejectNum int64
+}
+
+func newHTTPPartition(config HTTPBulkhead) *httpPartition {
+	p := &httpPartition{config: config}
+	if config.MaxConcurrent > 0 {
+		p.slots = make(chan bool, config.MaxConcurrent)
+	}
+	return p
+}
+
+type httpBulkheads struct {
+	config     HTTPBulkheads
+	lock       *sync.Mutex
+	partitions map[string]*httpPartition
+}
+
+// partition 获取分区，没有单独配置的分区按Default创建
+func (b *httpBulkheads) partition(name string) *httpPartition {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	p, ok := b.partitions[name]
+	if !ok {
+		config := b.config.Default
+		config.Name = name
+		p = newHTTPPartition(config)
+		b.partitions[name] = p
+	}
+	return p
+}
+
+// SetBulkheads 设置分区隔离，nil表示关闭。重新设置时正在执行的请求仍占用旧分区
+func (cp *HTTPConnectionPool) SetBulkheads(bulkheads *HTTPBulkheads) {
+	var b *httpBulkheads
+	if bulkheads != nil {
+		config := *bulkheads
+		if config.Partitioner == nil {
+			config.Partitioner = PartitionByHost()
+		}
+		b = &httpBulkheads{config: config, lock: new(sync.Mutex), partitions: make(map[string]*httpPartition)}
+		for _, partition := range config.Partitions {
+			b.partitions[partition.Name] = newHTTPPartition(partition)
+		}
+	}
+	cp.lock.Lock()
+	cp.bulkheads = b
+	cp.lock.Unlock()
+}
+
+func (cp *HTTPConnectionPool) getBulkheads() *httpBulkheads {
+	cp.lock.Lock()
+	defer cp.lock.Unlock()
+	return cp.bulkheads
+}
+
+// acquirePartition 入队前占用分区，分区已满时等待或者拒绝
+func (cp *HTTPConnectionPool) acquirePartition(httpData *HTTPData) error {
+	b := cp.getBulkheads()
+	if b == nil || httpData.Request == nil {
+		return nil
+	}
+	name := b.config.Partitioner(httpData.Request)
+	if name == "" {
+		return nil
+	}
+	p := b.partition(name)
+	atomic.AddInt64(&p.totalNum, 1)
+	if p.slots == nil {
+		return nil
+	}
+	select {
+	case p.slots <- true:
+		httpData.holdPartition(p)
+		return nil
+	default:
+	}
+	if atomic.AddInt64(&p.waiting, 1) > int64(p.config.QueueSize) {
+		atomic.AddInt64(&p.waiting, -1)
+		atomic.AddInt64(&p.rejectNum, 1)
+		return errorBulkheadFull
+	}
+	defer atomic.AddInt64(&p.waiting, -1)
+	timer := time.NewTimer(time.Until(httpData.deadline))
+	defer timer.Stop()
+	select {
+	case p.slots <- true:
+		httpData.holdPartition(p)
+		return nil
+	case <-timer.C:
+		atomic.AddInt64(&p.rejectNum, 1)
+		return errorBulkheadFull
+	case <-httpData.requestContext().Done():
+		return httpData.requestContext().Err()
+	case <-cp.closing:
+		return errorRequestPoolClosed
+	}
+}
+
+func (d *HTTPData) holdPartition(p *httpPartition) {
+	d.partitionLock.Lock()
+	d.partitions = append(d.partitions, p)
+	d.partitionLock.Unlock()
+}
+
+// releasePartition 请求执行完成或者没有执行就结束时释放一次入队占用的分区，
+// 和轮次无关，上一轮的worker晚于新一轮入队时也只释放一个
+func (d *HTTPData) releasePartition() {
+	d.partitionLock.Lock()
+	var p *httpPartition
+	if n := len(d.partitions); n > 0 {
+		p = d.partitions[n-1]
+		d.partitions = d.partitions[:n-1]
+	}
+	d.partitionLock.Unlock()
+	if p != nil {
+		<-p.slots
+	}
+}
+
+// PartitionStats 各分区的统计，按分区名排序
+func (cp *HTTPConnectionPool) PartitionStats() []HTTPPartitionStats {
+	b := cp.getBulkheads()
+	if b == nil {
+		return nil
+	}
+	b.lock.Lock()
+	stats := make([]HTTPPartitionStats, 0, len(b.partitions))
+	for name, p := range b.partitions {
+		stats = append(stats, HTTPPartitionStats{
+			Name:          name,
+			MaxConcurrent: p.config.MaxConcurrent,
+			Active:        len(p.slots),
+			Waiting:       atomic.LoadInt64(&p.waiting),
+			TotalNum:      atomic.LoadInt64(&p.totalNum),
+			RejectNum:     atomic.LoadInt64(&p.rejectNum),
+		})
+	}
+	b.lock.Unlock()
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Name < stats[j].Name })
+	return stats
+}
+
+// partitionStatus Status中的分区信息
+func (cp *HTTPConnectionPool) partitionStatus() string {
+	stats := cp.PartitionStats()
+	if stats == nil {
+		return ""
+	}
+	parts := make([]string, 0, len(stats))
+	for _, s := range stats {
+		parts = append(parts, fmt.Sprintf("%s:%d/%d,waiting=%d,total=%d,rejected=%d", s.Name, s.Active, s.MaxConcurrent, s.Waiting, s.TotalNum, s.RejectNum))
+	}
+	return fmt.Sprintf(", partitions=[%s]", strings.Join(parts, " "))
+}
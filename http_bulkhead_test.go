@@ -0,0 +1,125 @@
+package goutils
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_HTTPBulkheadIsolation(t *testing.T) {
+	slow := NewMockUpstream()
+	defer slow.Close()
+	slow.SetDefault(MockResponse{Delay: 100 * time.Millisecond})
+	fast := NewMockUpstream()
+	defer fast.Close()
+	pool := NewHTTPConnectionPoolWithOptions(WithTimeout(time.Second), WithPoolNum(4),
+		WithBulkheads(HTTPBulkheads{Default: HTTPBulkhead{MaxConcurrent: 2}}))
+	defer pool.Close()
+	time.Sleep(30 * time.Millisecond)
+	var batch []*HTTPData
+	for i := 0; i < 3; i++ {
+		batch = append(batch, NewHTTPData(newGetRequest(slow.URL)))
+	}
+	batch = append(batch, NewHTTPData(newGetRequest(fast.URL)))
+	pool.BatchRequest(batch)
+	if batch[0].Err != nil || batch[1].Err != nil || batch[3].Err != nil {
+		t.Errorf("err:%v %v %v", batch[0].Err, batch[1].Err, batch[3].Err)
+	}
+	if batch[2].Err != errorBulkheadFull {
+		t.Errorf("err:%v", batch[2].Err)
+	}
+	for _, data := range batch {
+		CloseResponse(data.Response)
+	}
+	stats := pool.PartitionStats()
+	if len(stats) != 2 {
+		t.Fatalf("stats:%+v", stats)
+	}
+	for _, s := range stats {
+		if s.Active != 0 {
+			t.Errorf("partition:%s active:%d", s.Name, s.Active)
+		}
+		if s.Name == slow.Addr() && (s.TotalNum != 3 || s.RejectNum != 1) {
+			t.Errorf("stats:%+v", s)
+		}
+	}
+	if status := pool.Status(); !strings.Contains(status, slow.Addr()+":0/2,waiting=0,total=3,rejected=1") {
+		t.Errorf("status:%s", status)
+	}
+}
+
+func Test_HTTPBulkheadReuse(t *testing.T) {
+	upstream := NewMockUpstream()
+	defer upstream.Close()
+	upstream.SetDefault(MockResponse{Delay: 60 * time.Millisecond})
+	pool := NewHTTPConnectionPoolWithOptions(WithTimeout(time.Second), WithPoolNum(4),
+		WithBulkheads(HTTPBulkheads{Default: HTTPBulkhead{MaxConcurrent: 2}}))
+	defer pool.Close()
+	httpData := NewHTTPData(newGetRequest(upstream.URL))
+	httpData.Timeout = 20 * time.Millisecond
+	if err := pool.Do(httpData); err != errorRequestCallTimeout {
+		t.Fatalf("err:%v", err)
+	}
+	// 上一轮的worker还在执行时重复使用
+	httpData.Timeout = 0
+	if err := pool.Do(httpData); err != nil {
+		t.Fatal(err.Error())
+	}
+	CloseResponse(httpData.Response)
+	time.Sleep(100 * time.Millisecond)
+	for _, s := range pool.PartitionStats() {
+		if s.Active != 0 {
+			t.Errorf("partition:%s active:%d", s.Name, s.Active)
+		}
+	}
+}
+
+func Test_HTTPBulkheadQueue(t *testing.T) {
+	upstream := NewMockUpstream()
+	defer upstream.Close()
+	upstream.Script("/api/slow", MockResponse{Delay: 50 * time.Millisecond})
+	pool := NewHTTPConnectionPool(time.Second, 4)
+	defer pool.Close()
+	pool.SetBulkheads(&HTTPBulkheads{
+		Partitioner: PartitionByPathPrefix("/api", "/api/slow"),
+		Partitions:  []HTTPBulkhead{{Name: "/api/slow", MaxConcurrent: 1, QueueSize: 1}},
+	})
+	time.Sleep(30 * time.Millisecond)
+	var batch []*HTTPData
+	for _, path := range []string{"/api/slow", "/api/slow", "/api/slow", "/api/other", "/static"} {
+		batch = append(batch, NewHTTPData(newGetRequest(upstream.URL+path)))
+	}
+	// 第二个请求在分区中等待，批量请求在等待期间不会提交后面的请求
+	pool.BatchRequest(batch)
+	for i, data := range batch {
+		if data.Err != nil {
+			t.Errorf("item:%d err:%v", i, data.Err)
+		}
+		CloseResponse(data.Response)
+	}
+	stats := pool.PartitionStats()
+	if len(stats) != 2 || stats[0].Name != "/api" || stats[1].Name != "/api/slow" || stats[1].TotalNum != 3 {
+		t.Errorf("stats:%+v", stats)
+	}
+
+	// 等待超过截止时间时拒绝
+	pool.SetTimeout(30 * time.Millisecond)
+	batch = []*HTTPData{NewHTTPData(newGetRequest(upstream.URL + "/api/slow")), NewHTTPData(newGetRequest(upstream.URL + "/api/slow"))}
+	pool.BatchRequest(batch)
+	if batch[1].Err != errorBulkheadFull {
+		t.Errorf("err:%v", batch[1].Err)
+	}
+}
+
+func Test_PartitionByPathPrefix(t *testing.T) {
+	partitioner := PartitionByPathPrefix("/a", "/a/b")
+	request, _ := http.NewRequest("GET", "http://host/a/b/c", nil)
+	if partitioner(request) != "/a/b" {
+		t.Fail()
+	}
+	request, _ = http.NewRequest("GET", "http://host/c", nil)
+	if partitioner(request) != "" {
+		t.Fail()
+	}
+}
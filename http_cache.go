@@ -0,0 +1,452 @@
+package goutils
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	defaultCacheCapacity           = 1000
+	defaultCacheMaxEntrySize int64 = 1 << 20
+	defaultCacheRetain             = 5 * time.Minute
+	cacheableStatus                = map[int]bool{200: true, 203: true, 204: true, 300: true, 301: true, 404: true, 410: true}
+)
+
+// HTTPCache 响应缓存配置，只缓存GET请求。
+// 响应的Cache-Control(max-age、no-cache、no-store、private、stale-while-revalidate、stale-if-error)和Expires优先于配置，
+// 有ETag或者Last-Modified的响应过期后用条件请求重新验证。
+// 缓存在多个调用方之间共享，Authorization和Cookie的摘要总是参与计算缓存key，
+// 带Authorization的请求只有响应为Cache-Control: public时才缓存
+type HTTPCache struct {
+	Store                HTTPCacheStore //缓存存储，默认为容量1000的内存LRU
+	TTL                  time.Duration  //响应没有指定过期时间时的缓存时间，0表示不缓存这类响应
+	MaxEntrySize         int64          //缓存的body最大字节数，默认1MB
+	StaleWhileRevalidate time.Duration  //过期后这段时间内直接返回旧响应，同时在后台重新验证
+	StaleIfError         time.Duration  //过期后这段时间内请求出错或者返回5xx时返回旧响应
+	Retain               time.Duration  //过期后保留多久用于重新验证，默认5分钟，只对有ETag或者Last-Modified的响应生效
+	KeyHeaders           []string       //除了URL之外参与计算缓存key的请求头，比如Accept-Language
+}
+
+// HTTPCacheEntry 缓存的响应
+type HTTPCacheEntry struct {
+	StatusCode           int
+	Header               http.Header
+	Body                 []byte
+	Expires              time.Time     //过期时间
+	StaleWhileRevalidate time.Duration //过期后直接返回旧响应并在后台重新验证的时间
+	StaleIfError         time.Duration //过期后出错时返回旧响应的时间
+}
+
+// HTTPCacheStore 缓存存储，ttl为存储需要保留该条目的时间
+type HTTPCacheStore interface {
+	Get(key string) (*HTTPCacheEntry, bool)
+	Set(key string, entry *HTTPCacheEntry, ttl time.Duration)
+	Delete(key string)
+}
+
+func (e *HTTPCacheEntry) response(request *http.Request) *http.Response {
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", e.StatusCode, http.StatusText(e.StatusCode)),
+		StatusCode:    e.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        e.Header.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(e.Body)),
+		ContentLength: int64(len(e.Body)),
+		Request:       request,
+	}
+}
+
+func (e *HTTPCacheEntry) validators() bool {
+	return e.Header.Get("ETag") != "" || e.Header.Get("Last-Modified") != ""
+}
+
+// lruCacheStore 内存LRU缓存
+type lruCacheStore struct {
+	lock     *sync.Mutex
+	capacity int
+	items    map[string]*list.Element
+	order    *list.List
+}
+
+type lruCacheItem struct {
+	key    string
+	entry  *HTTPCacheEntry
+	expire time.Time
+}
+
+// NewLRUCacheStore 内存LRU缓存，超过capacity时淘汰最久没有使用的条目
+func NewLRUCacheStore(capacity int) HTTPCacheStore {
+	if capacity <= 0 {
+		capacity = defaultCacheCapacity
+	}
+	return &lruCacheStore{lock: new(sync.Mutex), capacity: capacity, items: make(map[string]*list.Element), order: list.New()}
+}
+
+func (s *lruCacheStore) Get(key string) (*HTTPCacheEntry, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	element, ok := s.items[key]
+	if !ok {
+		return nil, false
+	}
+	item := element.Value.(*lruCacheItem)
+	if time.Now().After(item.expire) {
+		s.order.Remove(element)
+		delete(s.items, key)
+		return nil, false
+	}
+	s.order.MoveToFront(element)
+	return item.entry, true
+}
+
+func (s *lruCacheStore) Set(key string, entry *HTTPCacheEntry, ttl time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	item := &lruCacheItem{key: key, entry: entry, expire: time.Now().Add(ttl)}
+	if element, ok := s.items[key]; ok {
+		element.Value = item
+		s.order.MoveToFront(element)
+		return
+	}
+	s.items[key] = s.order.PushFront(item)
+	for s.order.Len() > s.capacity {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.items, oldest.Value.(*lruCacheItem).key)
+	}
+}
+
+func (s *lruCacheStore) Delete(key string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if element, ok := s.items[key]; ok {
+		s.order.Remove(element)
+		delete(s.items, key)
+	}
+}
+
+// redisCacheStore 以json格式存储在redis中，多个进程可以共享
+type redisCacheStore struct {
+	redis  *Redis
+	prefix string
+}
+
+// NewRedisCacheStore redis缓存存储，key加上prefix前缀
+func NewRedisCacheStore(redis *Redis, prefix string) HTTPCacheStore {
+	return &redisCacheStore{redis: redis, prefix: prefix}
+}
+
+func (s *redisCacheStore) Get(key string) (*HTTPCacheEntry, bool) {
+	value, err := s.redis.Get(s.prefix + key)
+	if err != nil || value == "" {
+		return nil, false
+	}
+	entry := new(HTTPCacheEntry)
+	if err := json.Unmarshal([]byte(value), entry); err != nil {
+		Log.Error("decode http cache key:%s error:%s", key, err.Error())
+		return nil, false
+	}
+	return entry, true
+}
+
+func (s *redisCacheStore) Set(key string, entry *HTTPCacheEntry, ttl time.Duration) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	if err := s.redis.Set(s.prefix+key, string(data), ttl); err != nil {
+		Log.Error("store http cache key:%s error:%s", key, err.Error())
+	}
+}
+
+func (s *redisCacheStore) Delete(key string) {
+	s.redis.Del(s.prefix + key)
+}
+
+type httpCache struct {
+	config       HTTPCache
+	lock         *sync.Mutex
+	revalidating map[string]bool
+}
+
+// httpCacheLookup 没有命中新鲜缓存的请求，worker收到响应后更新缓存
+type httpCacheLookup struct {
+	cache *httpCache
+	key   string
+	entry *HTTPCacheEntry //需要重新验证的旧响应
+}
+
+// SetCache 设置响应缓存，nil表示关闭
+func (cp *HTTPConnectionPool) SetCache(cache *HTTPCache) {
+	var c *httpCache
+	if cache != nil {
+		config := *cache
+		if config.Store == nil {
+			config.Store = NewLRUCacheStore(defaultCacheCapacity)
+		}
+		if config.MaxEntrySize <= 0 {
+			config.MaxEntrySize = defaultCacheMaxEntrySize
+		}
+		if config.Retain <= 0 {
+			config.Retain = defaultCacheRetain
+		}
+		c = &httpCache{config: config, lock: new(sync.Mutex), revalidating: make(map[string]bool)}
+	}
+	cp.lock.Lock()
+	cp.cache = c
+	cp.lock.Unlock()
+}
+
+func (cp *HTTPConnectionPool) getCache() *httpCache {
+	cp.lock.Lock()
+	defer cp.lock.Unlock()
+	return cp.cache
+}
+
+// lookupCache 查找缓存，命中新鲜的缓存或者可以先返回旧响应时设置结果并返回true
+func (cp *HTTPConnectionPool) lookupCache(httpData *HTTPData) bool {
+	c := cp.getCache()
+	request := httpData.Request
+	if c == nil || httpData.Stream || request == nil || request.Method != "GET" {
+		return false
+	}
+	directives := parseCacheControl(request.Header.Get("Cache-Control"))
+	if _, ok := directives["no-store"]; ok {
+		return false
+	}
+	_, noCache := directives["no-cache"]
+	lookup := &httpCacheLookup{cache: c, key: c.key(request)}
+	if entry, ok := c.config.Store.Get(lookup.key); ok {
+		now := time.Now()
+		if !noCache && now.Before(entry.Expires) {
+			atomic.AddInt64(&cp.stats.cacheHitNum, 1)
+			httpData.finish(entry.response(request), nil)
+			return true
+		}
+		if !noCache && now.Before(entry.Expires.Add(entry.StaleWhileRevalidate)) {
+			atomic.AddInt64(&cp.stats.cacheHitNum, 1)
+			if c.startRevalidate(lookup.key) {
+				go cp.revalidate(request, &httpCacheLookup{cache: c, key: lookup.key, entry: entry})
+			}
+			httpData.finish(entry.response(request), nil)
+			return true
+		}
+		lookup.entry = entry
+	}
+	httpData.cache = lookup
+	return false
+}
+
+// revalidate 后台重新验证，结果由worker写入缓存
+func (cp *HTTPConnectionPool) revalidate(request *http.Request, lookup *httpCacheLookup) {
+	defer lookup.cache.endRevalidate(lookup.key)
+	httpData := NewHTTPData(request.Clone(context.Background()))
+	cp.prepare(httpData, time.Now().Add(cp.GetTimeout()))
+	httpData.cache = lookup
+	cp.submit(httpData)
+	cp.wait(httpData)
+	if httpData.Err != nil {
+		Log.Warning("revalidate http cache url:%s error:%s", request.URL, httpData.Err.Error())
+		return
+	}
+	CloseResponse(httpData.Response)
+}
+
+func (c *httpCache) startRevalidate(key string) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.revalidating[key] {
+		return false
+	}
+	c.revalidating[key] = true
+	return true
+}
+
+func (c *httpCache) endRevalidate(key string) {
+	c.lock.Lock()
+	delete(c.revalidating, key)
+	c.lock.Unlock()
+}
+
+func (c *httpCache) key(request *http.Request) string {
+	key := request.Method + " " + request.URL.String()
+	for _, name := range c.config.KeyHeaders {
+		key += "\n" + name + ":" + request.Header.Get(name)
+	}
+	return key + credentialKey(request)
+}
+
+// credentialKey Authorization和Cookie的摘要，凭证不同的请求不能共用响应，摘要避免明文写入缓存存储
+func credentialKey(request *http.Request) string {
+	authorization, cookie := request.Header.Get("Authorization"), request.Header.Get("Cookie")
+	if authorization == "" && cookie == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(authorization + "\n" + cookie))
+	return "\ncredential:" + hex.EncodeToString(sum[:])
+}
+
+// condition 给重新验证的请求加上条件请求头
+func (l *httpCacheLookup) condition(request *http.Request) {
+	if l.entry == nil {
+		return
+	}
+	request.Header = request.Header.Clone()
+	if etag := l.entry.Header.Get("ETag"); etag != "" {
+		request.Header.Set("If-None-Match", etag)
+	}
+	if lastModified := l.entry.Header.Get("Last-Modified"); lastModified != "" {
+		request.Header.Set("If-Modified-Since", lastModified)
+	}
+}
+
+// update worker收到响应后更新缓存，304时返回缓存的响应，出错时按StaleIfError返回旧响应
+func (l *httpCacheLookup) update(request *http.Request, response *http.Response, err error) (*http.Response, error) {
+	c := l.cache
+	now := time.Now()
+	if l.entry != nil {
+		if err == nil && response.StatusCode == http.StatusNotModified {
+			CloseResponse(response)
+			header := l.entry.Header.Clone()
+			for key, values := range response.Header {
+				if key != "Content-Length" {
+					header[key] = values
+				}
+			}
+			entry := c.newEntry(request, l.entry.StatusCode, header, l.entry.Body, now)
+			c.store(l.key, entry, now)
+			return entry.response(request), nil
+		}
+		if (err != nil || response.StatusCode >= http.StatusInternalServerError) &&
+			now.Before(l.entry.Expires.Add(l.entry.StaleIfError)) {
+			CloseResponse(response)
+			Log.Warning("http request url:%s failed, use stale cache", request.URL)
+			return l.entry.response(request), nil
+		}
+	}
+	if err != nil || !cacheableStatus[response.StatusCode] {
+		return response, err
+	}
+	data, err := io.ReadAll(io.LimitReader(response.Body, c.config.MaxEntrySize+1))
+	if err != nil {
+		response.Body.Close()
+		return nil, err
+	}
+	if int64(len(data)) > c.config.MaxEntrySize {
+		response.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(data), response.Body), response.Body}
+		return response, nil
+	}
+	response.Body.Close()
+	response.Body = io.NopCloser(bytes.NewReader(data))
+	c.store(l.key, c.newEntry(request, response.StatusCode, response.Header.Clone(), data, now), now)
+	return response, nil
+}
+
+// newEntry 按响应头计算过期时间，不能缓存时返回nil
+func (c *httpCache) newEntry(request *http.Request, statusCode int, header http.Header, body []byte, now time.Time) *HTTPCacheEntry {
+	if header.Get("Vary") == "*" {
+		return nil
+	}
+	directives := parseCacheControl(header.Get("Cache-Control"))
+	if _, ok := directives["no-store"]; ok {
+		return nil
+	}
+	if _, ok := directives["private"]; ok {
+		return nil
+	}
+	if _, ok := directives["public"]; !ok && request.Header.Get("Authorization") != "" {
+		return nil
+	}
+	entry := &HTTPCacheEntry{
+		StatusCode:           statusCode,
+		Header:               header,
+		Body:                 body,
+		StaleWhileRevalidate: directiveSeconds(directives, "stale-while-revalidate", c.config.StaleWhileRevalidate),
+		StaleIfError:         directiveSeconds(directives, "stale-if-error", c.config.StaleIfError),
+	}
+	fresh := c.config.TTL
+	if _, ok := directives["no-cache"]; ok {
+		fresh = 0
+	} else if _, ok := directives["max-age"]; ok {
+		fresh = directiveSeconds(directives, "max-age", 0)
+	} else if expires := header.Get("Expires"); expires != "" {
+		fresh = 0
+		if t, err := http.ParseTime(expires); err == nil {
+			date, err := http.ParseTime(header.Get("Date"))
+			if err != nil {
+				date = now
+			}
+			fresh = t.Sub(date)
+		}
+	}
+	if fresh <= 0 && !entry.validators() {
+		return nil
+	}
+	if fresh < 0 {
+		fresh = 0
+	}
+	entry.Expires = now.Add(fresh)
+	return entry
+}
+
+func (c *httpCache) store(key string, entry *HTTPCacheEntry, now time.Time) {
+	if entry == nil {
+		c.config.Store.Delete(key)
+		return
+	}
+	ttl := entry.Expires.Sub(now)
+	stale := entry.StaleWhileRevalidate
+	if entry.StaleIfError > stale {
+		stale = entry.StaleIfError
+	}
+	if entry.validators() && c.config.Retain > stale {
+		stale = c.config.Retain
+	}
+	c.config.Store.Set(key, entry, ttl+stale)
+}
+
+// parseCacheControl 解析Cache-Control，key为小写的指令名
+func parseCacheControl(value string) map[string]string {
+	directives := make(map[string]string)
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, arg := part, ""
+		if index := strings.IndexByte(part, '='); index >= 0 {
+			name, arg = part[:index], strings.Trim(part[index+1:], `"`)
+		}
+		directives[strings.ToLower(strings.TrimSpace(name))] = arg
+	}
+	return directives
+}
+
+func directiveSeconds(directives map[string]string, name string, defaultValue time.Duration) time.Duration {
+	value, ok := directives[name]
+	if !ok {
+		return defaultValue
+	}
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds < 0 {
+		return defaultValue
+	}
+	return time.Duration(seconds) * time.Second
+}
@@ -0,0 +1,230 @@
+package goutils
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newCacheServer 按path返回不同缓存头的响应，body为请求次数
+func newCacheServer(count *int64, fail *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt64(count, 1)
+		if atomic.LoadInt32(fail) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		switch r.URL.Path {
+		case "/max-age":
+			w.Header().Set("Cache-Control", "max-age=60")
+		case "/public":
+			w.Header().Set("Cache-Control", "public, max-age=60")
+		case "/private":
+			w.Header().Set("Cache-Control", "private, max-age=60")
+		case "/etag":
+			w.Header().Set("Cache-Control", "no-cache")
+			w.Header().Set("ETag", `"v1"`)
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		case "/no-store":
+			w.Header().Set("Cache-Control", "no-store")
+		case "/stale":
+			w.Header().Set("Cache-Control", "max-age=0, stale-while-revalidate=60")
+			w.Header().Set("Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT")
+		}
+		w.Write([]byte(strconv.FormatInt(n, 10)))
+	}))
+}
+
+func cachedBody(t *testing.T, pool *HTTPConnectionPool, url string) string {
+	response, err := pool.Request(newGetRequest(url))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer response.Body.Close()
+	body, _ := io.ReadAll(response.Body)
+	return string(body)
+}
+
+func Test_HTTPCacheMaxAge(t *testing.T) {
+	var count int64
+	var fail int32
+	server := newCacheServer(&count, &fail)
+	defer server.Close()
+	pool := NewHTTPConnectionPoolWithOptions(WithTimeout(time.Second), WithPoolNum(2), WithCache(HTTPCache{}))
+	defer pool.Close()
+	for i := 0; i < 3; i++ {
+		if body := cachedBody(t, pool, server.URL+"/max-age"); body != "1" {
+			t.Errorf("body:%s", body)
+		}
+	}
+	if stats := pool.loadStats(); stats.cacheHitNum != 2 {
+		t.Errorf("cacheHitNum:%d", stats.cacheHitNum)
+	}
+	// 没有过期时间的响应按TTL缓存，默认不缓存
+	cachedBody(t, pool, server.URL+"/plain")
+	if body := cachedBody(t, pool, server.URL+"/plain"); body != "3" {
+		t.Errorf("body:%s", body)
+	}
+	cachedBody(t, pool, server.URL+"/no-store")
+	if body := cachedBody(t, pool, server.URL+"/no-store"); body != "5" {
+		t.Errorf("body:%s", body)
+	}
+}
+
+func credentialBody(t *testing.T, pool *HTTPConnectionPool, url, name, value string) string {
+	request := newGetRequest(url)
+	request.Header.Set(name, value)
+	response, err := pool.Request(request)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer response.Body.Close()
+	body, _ := io.ReadAll(response.Body)
+	return string(body)
+}
+
+func Test_HTTPCacheCredential(t *testing.T) {
+	var count int64
+	var fail int32
+	server := newCacheServer(&count, &fail)
+	defer server.Close()
+	pool := NewHTTPConnectionPoolWithOptions(WithTimeout(time.Second), WithPoolNum(2), WithCache(HTTPCache{}))
+	defer pool.Close()
+	// 带Authorization的响应不是public时不缓存
+	credentialBody(t, pool, server.URL+"/max-age", "Authorization", "Bearer a")
+	if body := credentialBody(t, pool, server.URL+"/max-age", "Authorization", "Bearer a"); body != "2" {
+		t.Errorf("body:%s", body)
+	}
+	// 不同用户的缓存互不可见
+	if body := cachedBody(t, pool, server.URL+"/max-age"); body != "3" {
+		t.Errorf("body:%s", body)
+	}
+	if body := credentialBody(t, pool, server.URL+"/max-age", "Cookie", "session=a"); body != "4" {
+		t.Errorf("body:%s", body)
+	}
+	if body := credentialBody(t, pool, server.URL+"/max-age", "Cookie", "session=b"); body != "5" {
+		t.Errorf("body:%s", body)
+	}
+	if body := credentialBody(t, pool, server.URL+"/max-age", "Cookie", "session=a"); body != "4" {
+		t.Errorf("body:%s", body)
+	}
+	credentialBody(t, pool, server.URL+"/public", "Authorization", "Bearer a")
+	if body := credentialBody(t, pool, server.URL+"/public", "Authorization", "Bearer a"); body != "6" {
+		t.Errorf("body:%s", body)
+	}
+	if body := credentialBody(t, pool, server.URL+"/public", "Authorization", "Bearer b"); body != "7" {
+		t.Errorf("body:%s", body)
+	}
+	cachedBody(t, pool, server.URL+"/private")
+	if body := cachedBody(t, pool, server.URL+"/private"); body != "9" {
+		t.Errorf("body:%s", body)
+	}
+}
+
+func Test_HTTPCacheBatch(t *testing.T) {
+	var count int64
+	var fail int32
+	server := newCacheServer(&count, &fail)
+	defer server.Close()
+	pool := NewHTTPConnectionPool(time.Second, 4)
+	defer pool.Close()
+	pool.SetCache(&HTTPCache{TTL: time.Minute})
+	cachedBody(t, pool, server.URL+"/plain")
+	batch := []*HTTPData{NewHTTPData(newGetRequest(server.URL + "/plain")), NewHTTPData(newGetRequest(server.URL + "/other"))}
+	pool.BatchRequest(batch)
+	for _, data := range batch {
+		if data.Err != nil {
+			t.Fatal(data.Err.Error())
+		}
+		CloseResponse(data.Response)
+	}
+	if count != 2 {
+		t.Errorf("count:%d", count)
+	}
+}
+
+func Test_HTTPCacheRevalidate(t *testing.T) {
+	var count int64
+	var fail int32
+	server := newCacheServer(&count, &fail)
+	defer server.Close()
+	pool := NewHTTPConnectionPool(time.Second, 2)
+	defer pool.Close()
+	pool.SetCache(&HTTPCache{StaleIfError: time.Minute})
+	for i := 0; i < 3; i++ {
+		if body := cachedBody(t, pool, server.URL+"/etag"); body != "1" {
+			t.Errorf("body:%s", body)
+		}
+	}
+	if count != 3 {
+		t.Errorf("expect revalidate every request, count:%d", count)
+	}
+	atomic.StoreInt32(&fail, 1)
+	if body := cachedBody(t, pool, server.URL+"/etag"); body != "1" {
+		t.Errorf("expect stale body on error, body:%s", body)
+	}
+}
+
+func Test_HTTPCacheStaleWhileRevalidate(t *testing.T) {
+	var count int64
+	var fail int32
+	server := newCacheServer(&count, &fail)
+	defer server.Close()
+	pool := NewHTTPConnectionPool(time.Second, 2)
+	defer pool.Close()
+	pool.SetCache(&HTTPCache{})
+	cachedBody(t, pool, server.URL+"/stale")
+	if body := cachedBody(t, pool, server.URL+"/stale"); body != "1" {
+		t.Errorf("expect stale body, body:%s", body)
+	}
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt64(&count) < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if body := cachedBody(t, pool, server.URL+"/stale"); body != "2" {
+		t.Errorf("expect revalidated body, body:%s", body)
+	}
+}
+
+func Test_LRUCacheStore(t *testing.T) {
+	store := NewLRUCacheStore(2)
+	store.Set("a", &HTTPCacheEntry{StatusCode: 200}, time.Minute)
+	store.Set("b", &HTTPCacheEntry{StatusCode: 200}, time.Minute)
+	store.Get("a")
+	store.Set("c", &HTTPCacheEntry{StatusCode: 200}, time.Minute)
+	if _, ok := store.Get("b"); ok {
+		t.Error("expect b evicted")
+	}
+	if _, ok := store.Get("a"); !ok {
+		t.Error("expect a kept")
+	}
+	store.Set("d", &HTTPCacheEntry{StatusCode: 200}, -time.Second)
+	if _, ok := store.Get("d"); ok {
+		t.Error("expect d expired")
+	}
+	store.Delete("a")
+	if _, ok := store.Get("a"); ok {
+		t.Error("expect a deleted")
+	}
+}
+
+func Test_ParseCacheControl(t *testing.T) {
+	directives := parseCacheControl(`max-age=60, No-Cache, stale-if-error="30"`)
+	if directives["max-age"] != "60" || directives["stale-if-error"] != "30" {
+		t.Errorf("directives:%v", directives)
+	}
+	if _, ok := directives["no-cache"]; !ok {
+		t.Errorf("directives:%v", directives)
+	}
+	if directiveSeconds(directives, "stale-if-error", 0) != 30*time.Second {
+		t.Fail()
+	}
+}
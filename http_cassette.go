@@ -0,0 +1,177 @@
+package goutils
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+var errorCassetteMiss = errors.New("ERROR_HTTP_CASSETTE_MISS")
+
+// CassetteMode 录制或者回放
+type CassetteMode int
+
+const (
+	// CassetteReplay 从文件回放，没有匹配的记录时返回错误
+	CassetteReplay CassetteMode = iota
+	// CassetteRecord 发出真实请求并记录，调用Save写入文件
+	CassetteRecord
+)
+
+// CassetteInteraction 一次记录的请求和响应
+type CassetteInteraction struct {
+	Method      string        `json:"method"`
+	URL         string        `json:"url"`
+	RequestBody string        `json:"request_body,omitempty"`
+	Status      int           `json:"status,omitempty"`
+	Header      http.Header   `json:"header,omitempty"`
+	Body        string        `json:"body,omitempty"`
+	Latency     time.Duration `json:"latency,omitempty"` //回放时按记录的耗时延迟返回
+	Error       string        `json:"error,omitempty"`   //请求出错时的错误信息
+}
+
+// Cassette 录制和回放请求的RoundTripper，通过WithTransport注入连接池。
+// 回放时按方法、URL和请求body依次匹配没有用过的记录
+type Cassette struct {
+	path         string
+	mode         CassetteMode
+	next         http.RoundTripper
+	lock         *sync.Mutex
+	interactions []*CassetteInteraction
+	used         []bool
+}
+
+// NewCassette 回放模式从path读取记录，录制模式通过next发出请求，next为nil时使用http.DefaultTransport
+func NewCassette(path string, mode CassetteMode, next http.RoundTripper) (*Cassette, error) {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	c := &Cassette{path: path, mode: mode, next: next, lock: new(sync.Mutex)}
+	if mode == CassetteReplay {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, &c.interactions); err != nil {
+			return nil, err
+		}
+		c.used = make([]bool, len(c.interactions))
+	}
+	return c, nil
+}
+
+// Save 把录制的记录写入文件
+func (c *Cassette) Save() error {
+	c.lock.Lock()
+	data, err := json.MarshalIndent(c.interactions, "", "  ")
+	c.lock.Unlock()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(c.path, data, 0644)
+}
+
+// RoundTrip 实现http.RoundTripper
+func (c *Cassette) RoundTrip(request *http.Request) (*http.Response, error) {
+	body, err := readRequestBody(request)
+	if err != nil {
+		return nil, err
+	}
+	if c.mode == CassetteRecord {
+		return c.record(request, body)
+	}
+	interaction := c.match(request, body)
+	if interaction == nil {
+		return nil, fmt.Errorf("%w: %s %s", errorCassetteMiss, request.Method, request.URL)
+	}
+	if interaction.Latency > 0 {
+		timer := time.NewTimer(interaction.Latency)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-request.Context().Done():
+			return nil, request.Context().Err()
+		}
+	}
+	if interaction.Error != "" {
+		return nil, errors.New(interaction.Error)
+	}
+	return interaction.response(request), nil
+}
+
+func (c *Cassette) record(request *http.Request, body string) (*http.Response, error) {
+	interaction := &CassetteInteraction{Method: request.Method, URL: request.URL.String(), RequestBody: body}
+	start := time.Now()
+	response, err := c.next.RoundTrip(request)
+	if err == nil {
+		var data []byte
+		data, err = io.ReadAll(response.Body)
+		response.Body.Close()
+		if err == nil {
+			interaction.Status, interaction.Header, interaction.Body = response.StatusCode, response.Header, string(data)
+			response.Body = io.NopCloser(bytes.NewReader(data))
+		}
+	}
+	interaction.Latency = time.Since(start)
+	if err != nil {
+		interaction.Error = err.Error()
+	}
+	c.lock.Lock()
+	c.interactions = append(c.interactions, interaction)
+	c.lock.Unlock()
+	return response, err
+}
+
+func (c *Cassette) match(request *http.Request, body string) *CassetteInteraction {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for i, interaction := range c.interactions {
+		if !c.used[i] && interaction.Method == request.Method && interaction.URL == request.URL.String() && interaction.RequestBody == body {
+			c.used[i] = true
+			return interaction
+		}
+	}
+	return nil
+}
+
+func (i *CassetteInteraction) response(request *http.Request) *http.Response {
+	status := i.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	header := i.Header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader([]byte(i.Body))),
+		ContentLength: int64(len(i.Body)),
+		Request:       request,
+	}
+}
+
+// readRequestBody 读取请求body并恢复，供匹配和记录使用
+func readRequestBody(request *http.Request) (string, error) {
+	if request.Body == nil || request.Body == http.NoBody {
+		return "", nil
+	}
+	data, err := io.ReadAll(request.Body)
+	request.Body.Close()
+	if err != nil {
+		return "", err
+	}
+	request.Body = io.NopCloser(bytes.NewReader(data))
+	return string(data), nil
+}
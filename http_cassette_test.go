@@ -0,0 +1,76 @@
+package goutils
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_CassetteRecordReplay(t *testing.T) {
+	upstream := NewMockUpstream()
+	upstream.Script("/a", MockResponse{Body: "a", Delay: 20 * time.Millisecond})
+	upstream.Script("/b", MockResponse{Status: http.StatusNotFound, Body: "b"})
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	recorder, err := NewCassette(path, CassetteRecord, nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	pool := NewHTTPConnectionPoolWithOptions(WithTimeout(time.Second), WithPoolNum(2), WithTransport(recorder))
+	requestBody(pool, upstream.URL+"/a")
+	requestBody(pool, upstream.URL+"/b")
+	pool.Close()
+	upstream.Close()
+	if err := recorder.Save(); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	player, err := NewCassette(path, CassetteReplay, nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	pool = NewHTTPConnectionPool(time.Second, 2)
+	defer pool.Close()
+	pool.SetTransport(player)
+	if body, err := requestBody(pool, upstream.URL+"/a"); err != nil || body != "a" {
+		t.Errorf("body:%s err:%v", body, err)
+	}
+	response, err := pool.Request(newGetRequest(upstream.URL + "/b"))
+	if err != nil || response.StatusCode != http.StatusNotFound {
+		t.Fatalf("response:%v err:%v", response, err)
+	}
+	CloseResponse(response)
+	// 每条记录只回放一次
+	if _, err := pool.Request(newGetRequest(upstream.URL + "/a")); !errors.Is(err, errorCassetteMiss) {
+		t.Errorf("err:%v", err)
+	}
+}
+
+func Test_CassetteReplayFile(t *testing.T) {
+	player, err := NewCassette("testdata/pool.cassette.json", CassetteReplay, nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	pool := NewHTTPConnectionPoolWithOptions(WithTimeout(100*time.Millisecond), WithPoolNum(1), WithTransport(player))
+	defer pool.Close()
+	response, err := pool.Request(newGetRequest("http://cassette.test/ok"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	body, _ := io.ReadAll(response.Body)
+	response.Body.Close()
+	if string(body) != "ok" || response.Header.Get("Content-Type") != "text/plain" {
+		t.Errorf("body:%s header:%v", body, response.Header)
+	}
+	// 记录的耗时超过连接池超时时间，结果确定为超时
+	if _, err := pool.Request(newGetRequest("http://cassette.test/slow")); err != errorRequestCallTimeout {
+		t.Errorf("err:%v", err)
+	}
+	request, _ := http.NewRequest("POST", "http://cassette.test/error", strings.NewReader("payload"))
+	if _, err := pool.Request(request); err == nil || !strings.Contains(err.Error(), "connection reset by peer") {
+		t.Errorf("err:%v", err)
+	}
+}
@@ -0,0 +1,217 @@
+package goutils
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var defaultCoalesceMethods = []string{"GET", "HEAD"}
+
+// HTTPCoalesce 合并相同的请求：方法、URL、Authorization、Cookie和Headers中的请求头都相同的请求共用一次调用，
+// 调用在独立的context中按第一个请求的超时时间执行，所有等待的请求都离开后才取消，每个请求得到响应的一份拷贝。
+// 调用超时或者被取消时，还没有超时的请求重新发起调用
+type HTTPCoalesce struct {
+	Methods []string //允许合并的请求方法，默认GET、HEAD
+	Headers []string //参与比较的请求头
+	MaxSize int64    //缓冲body的最大字节数，默认使用连接池的MaxResponseSize
+}
+
+type httpCoalescer struct {
+	config  HTTPCoalesce
+	lock    *sync.Mutex
+	flights map[string]*httpFlight
+}
+
+// httpFlight 一次正在执行的调用
+type httpFlight struct {
+	done      chan bool
+	response  *http.Response
+	body      []byte
+	err       error
+	queueWait time.Duration
+	execTime  time.Duration
+	waiters   int //还在等待的请求数，包括发起调用的请求
+	ctx       context.Context
+	cancel    context.CancelFunc
+}
+
+// SetCoalesce 设置相同请求合并，nil表示关闭
+func (cp *HTTPConnectionPool) SetCoalesce(coalesce *HTTPCoalesce) {
+	var c *httpCoalescer
+	if coalesce != nil {
+		config := *coalesce
+		if len(config.Methods) == 0 {
+			config.Methods = defaultCoalesceMethods
+		}
+		c = &httpCoalescer{config: config, lock: new(sync.Mutex), flights: make(map[string]*httpFlight)}
+	}
+	cp.lock.Lock()
+	cp.coalescer = c
+	cp.lock.Unlock()
+}
+
+func (cp *HTTPConnectionPool) getCoalescer() *httpCoalescer {
+	cp.lock.Lock()
+	defer cp.lock.Unlock()
+	return cp.coalescer
+}
+
+func (c *httpCoalescer) eligible(httpData *HTTPData) bool {
+	request := httpData.Request
+	if httpData.Stream || request == nil || (request.Body != nil && request.Body != http.NoBody) {
+		return false
+	}
+	for _, method := range c.config.Methods {
+		if method == request.Method {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *httpCoalescer) key(request *http.Request) string {
+	key := request.Method + " " + request.URL.String()
+	for _, name := range c.config.Headers {
+		key += "\n" + name + ":" + request.Header.Get(name)
+	}
+	return key + credentialKey(request)
+}
+
+// join 加入key对应的调用，没有时新建并返回true，调用方负责发起。
+// 调用的context保留ctx中的值，但不随ctx取消
+func (c *httpCoalescer) join(key string, ctx context.Context) (*httpFlight, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if flight, ok := c.flights[key]; ok {
+		flight.waiters++
+		return flight, false
+	}
+	flight := &httpFlight{done: make(chan bool), waiters: 1}
+	flight.ctx, flight.cancel = context.WithCancel(context.WithoutCancel(ctx))
+	c.flights[key] = flight
+	return flight, true
+}
+
+// leave 请求放弃等待，最后一个请求离开时取消调用
+func (c *httpCoalescer) leave(key string, flight *httpFlight) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	flight.waiters--
+	if flight.waiters > 0 {
+		return
+	}
+	if c.flights[key] == flight {
+		delete(c.flights, key)
+	}
+	flight.cancel()
+}
+
+// land 调用完成，之后的请求发起新的调用
+func (c *httpCoalescer) land(key string, flight *httpFlight) {
+	c.lock.Lock()
+	if c.flights[key] == flight {
+		delete(c.flights, key)
+	}
+	c.lock.Unlock()
+}
+
+// interrupted 调用因为超时或者取消而失败，不是上游返回的结果
+func (f *httpFlight) interrupted() bool {
+	return f.err == errorRequestCallTimeout || errors.Is(f.err, context.Canceled) || errors.Is(f.err, context.DeadlineExceeded)
+}
+
+// settle 读取body到内存中并记录结果
+func (f *httpFlight) settle(httpData *HTTPData, maxSize int64) {
+	f.err = httpData.Err
+	f.queueWait, f.execTime = httpData.QueueWait, httpData.ExecTime
+	if f.err != nil {
+		return
+	}
+	response := httpData.Response
+	defer response.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(response.Body, maxSize+1))
+	if err == nil && int64(len(body)) > maxSize {
+		err = errorResponseTooLarge
+	}
+	if err != nil {
+		f.err = err
+		return
+	}
+	f.response, f.body = response, body
+}
+
+// result 把调用结果的一份拷贝写入httpData
+func (f *httpFlight) result(httpData *HTTPData) {
+	httpData.Response, httpData.Err = nil, f.err
+	httpData.QueueWait, httpData.ExecTime = f.queueWait, f.execTime
+	if f.err != nil {
+		return
+	}
+	response := new(http.Response)
+	*response = *f.response
+	response.Header = f.response.Header.Clone()
+	response.Body = io.NopCloser(bytes.NewReader(f.body))
+	response.ContentLength = int64(len(f.body))
+	response.Request = httpData.Request
+	httpData.Response = response
+}
+
+// doCoalesced 相同的请求正在执行时等待它的结果，否则发起调用并等待，调用的结果分享给所有等待的请求。
+// 调用因为超时或者取消失败而请求还没有超时时，重新加入或者发起调用
+func (cp *HTTPConnectionPool) doCoalesced(httpData *HTTPData, c *httpCoalescer) {
+	key := c.key(httpData.Request)
+	ctx := httpData.requestContext()
+	timer := time.NewTimer(time.Until(httpData.deadline))
+	defer timer.Stop()
+	coalesced := false
+	for {
+		flight, leader := c.join(key, ctx)
+		if leader {
+			go cp.fly(httpData, c, key, flight)
+		} else if !coalesced {
+			coalesced = true
+			atomic.AddInt64(&cp.stats.coalescedNum, 1)
+		}
+		select {
+		case <-flight.done:
+			if !flight.interrupted() || ctx.Err() != nil || !time.Now().Before(httpData.deadline) {
+				flight.result(httpData)
+				return
+			}
+		case <-timer.C:
+			c.leave(key, flight)
+			httpData.Err = errorRequestCallTimeout
+			cp.addTimeout()
+			return
+		case <-ctx.Done():
+			c.leave(key, flight)
+			httpData.Err = ctx.Err()
+			return
+		}
+	}
+}
+
+// fly 在调用自己的context中按发起请求的截止时间执行，读取body后通知等待的请求
+func (cp *HTTPConnectionPool) fly(httpData *HTTPData, c *httpCoalescer, key string, flight *httpFlight) {
+	shared := NewHTTPData(httpData.Request.WithContext(flight.ctx))
+	shared.Priority = httpData.Priority
+	shared.Progress = httpData.Progress
+	shared.cache = httpData.cache
+	shared.enqueued = time.Now()
+	shared.deadline = httpData.deadline
+	cp.doRequest(shared)
+	maxSize := c.config.MaxSize
+	if maxSize <= 0 {
+		maxSize = cp.getMaxResponseSize()
+	}
+	flight.settle(shared, maxSize)
+	flight.cancel()
+	c.land(key, flight)
+	close(flight.done)
+}
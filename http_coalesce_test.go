@@ -0,0 +1,194 @@
+package goutils
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newCountServer(count *int64, delay time.Duration) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt64(count, 1)
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+		w.Header().Set("X-Lang", r.Header.Get("Accept-Language"))
+		w.Write([]byte(strconv.FormatInt(n, 10)))
+	}))
+}
+
+func Test_HTTPCoalesce(t *testing.T) {
+	var count int64
+	server := newCountServer(&count, 50*time.Millisecond)
+	defer server.Close()
+	pool := NewHTTPConnectionPoolWithOptions(WithTimeout(time.Second), WithPoolNum(4), WithCoalesce(HTTPCoalesce{}))
+	defer pool.Close()
+	var wg sync.WaitGroup
+	bodies := make([]string, 10)
+	for i := range bodies {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			response, err := pool.Request(newGetRequest(server.URL))
+			if err != nil {
+				t.Error(err.Error())
+				return
+			}
+			body, _ := io.ReadAll(response.Body)
+			response.Body.Close()
+			bodies[i] = string(body)
+		}(i)
+	}
+	wg.Wait()
+	if count != 1 {
+		t.Errorf("count:%d", count)
+	}
+	for _, body := range bodies {
+		if body != "1" {
+			t.Errorf("bodies:%v", bodies)
+			break
+		}
+	}
+	if stats := pool.loadStats(); stats.coalescedNum != 9 {
+		t.Errorf("coalescedNum:%d", stats.coalescedNum)
+	}
+}
+
+func Test_HTTPCoalesceHeaders(t *testing.T) {
+	var count int64
+	server := newCountServer(&count, 50*time.Millisecond)
+	defer server.Close()
+	pool := NewHTTPConnectionPool(time.Second, 4)
+	defer pool.Close()
+	pool.SetCoalesce(&HTTPCoalesce{Headers: []string{"Accept-Language"}})
+	var batch []*HTTPData
+	for _, lang := range []string{"zh", "zh", "en"} {
+		request := newGetRequest(server.URL)
+		request.Header.Set("Accept-Language", lang)
+		batch = append(batch, NewHTTPData(request))
+	}
+	pool.BatchRequest(batch)
+	for i, data := range batch {
+		if data.Err != nil {
+			t.Fatal(data.Err.Error())
+		}
+		if lang := data.Response.Header.Get("X-Lang"); lang != data.Request.Header.Get("Accept-Language") {
+			t.Errorf("item:%d lang:%s", i, lang)
+		}
+		CloseResponse(data.Response)
+	}
+	if count != 2 {
+		t.Errorf("count:%d", count)
+	}
+}
+
+func Test_HTTPCoalesceCredential(t *testing.T) {
+	var count int64
+	server := newCountServer(&count, 50*time.Millisecond)
+	defer server.Close()
+	pool := NewHTTPConnectionPoolWithOptions(WithTimeout(time.Second), WithPoolNum(4), WithCoalesce(HTTPCoalesce{}))
+	defer pool.Close()
+	var batch []*HTTPData
+	for _, header := range [][2]string{{"Authorization", "Bearer a"}, {"Authorization", "Bearer a"}, {"Authorization", "Bearer b"}, {"Cookie", "session=a"}, {"", ""}} {
+		request := newGetRequest(server.URL)
+		if header[0] != "" {
+			request.Header.Set(header[0], header[1])
+		}
+		batch = append(batch, NewHTTPData(request))
+	}
+	pool.BatchRequest(batch)
+	for _, data := range batch {
+		if data.Err != nil {
+			t.Fatal(data.Err.Error())
+		}
+		CloseResponse(data.Response)
+	}
+	if count != 4 {
+		t.Errorf("count:%d", count)
+	}
+}
+
+// coalescedBody 延迟delay后发出请求并返回body
+func coalescedBody(pool *HTTPConnectionPool, httpData *HTTPData, delay time.Duration, body chan string) {
+	time.Sleep(delay)
+	if err := pool.Do(httpData); err != nil {
+		body <- err.Error()
+		return
+	}
+	data, _ := io.ReadAll(httpData.Response.Body)
+	httpData.Response.Body.Close()
+	body <- string(data)
+}
+
+func Test_HTTPCoalesceLeaderCancel(t *testing.T) {
+	var count int64
+	server := newCountServer(&count, 100*time.Millisecond)
+	defer server.Close()
+	pool := NewHTTPConnectionPoolWithOptions(WithTimeout(time.Second), WithPoolNum(4), WithCoalesce(HTTPCoalesce{}))
+	defer pool.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(30*time.Millisecond, cancel)
+	leader, follower := make(chan string, 1), make(chan string, 1)
+	go coalescedBody(pool, NewHTTPData(newGetRequest(server.URL).WithContext(ctx)), 0, leader)
+	go coalescedBody(pool, NewHTTPData(newGetRequest(server.URL)), 10*time.Millisecond, follower)
+	if body := <-leader; body != context.Canceled.Error() {
+		t.Errorf("leader body:%s", body)
+	}
+	if body := <-follower; body != "1" || atomic.LoadInt64(&count) != 1 {
+		t.Errorf("follower body:%s count:%d", body, count)
+	}
+}
+
+func Test_HTTPCoalesceLeaderTimeout(t *testing.T) {
+	var count int64
+	server := newCountServer(&count, 100*time.Millisecond)
+	defer server.Close()
+	pool := NewHTTPConnectionPoolWithOptions(WithTimeout(time.Second), WithPoolNum(4), WithCoalesce(HTTPCoalesce{}))
+	defer pool.Close()
+	leaderData := NewHTTPData(newGetRequest(server.URL))
+	leaderData.Timeout = 50 * time.Millisecond
+	leader, follower := make(chan string, 1), make(chan string, 1)
+	go coalescedBody(pool, leaderData, 0, leader)
+	go coalescedBody(pool, NewHTTPData(newGetRequest(server.URL)), 10*time.Millisecond, follower)
+	if body := <-leader; body != errorRequestCallTimeout.Error() {
+		t.Errorf("leader body:%s", body)
+	}
+	// 第一次调用按leader的截止时间超时后，follower重新发起调用
+	if body := <-follower; body != "2" {
+		t.Errorf("follower body:%s", body)
+	}
+}
+
+func Test_HTTPCoalesceWaiterTimeout(t *testing.T) {
+	var count int64
+	server := newCountServer(&count, 200*time.Millisecond)
+	defer server.Close()
+	pool := NewHTTPConnectionPool(time.Second, 4)
+	defer pool.Close()
+	pool.SetCoalesce(&HTTPCoalesce{})
+	done := make(chan bool)
+	go func() {
+		response, err := pool.Request(newGetRequest(server.URL))
+		if err == nil {
+			CloseResponse(response)
+		}
+		done <- err == nil
+	}()
+	time.Sleep(20 * time.Millisecond)
+	waiter := NewHTTPData(newGetRequest(server.URL))
+	waiter.Timeout = 50 * time.Millisecond
+	if err := pool.Do(waiter); err != errorRequestCallTimeout {
+		t.Errorf("err:%v", err)
+	}
+	if !<-done {
+		t.Error("leader failed")
+	}
+}
@@ -0,0 +1,133 @@
+package goutils
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+)
+
+var errorDecodePanic = errors.New("ERROR_HTTP_DECODE_PANIC")
+
+// HTTPValidator 检查响应，body为完整的响应内容，返回错误时不再解析
+type HTTPValidator func(response *http.Response, body []byte) error
+
+// HTTPDecoder 解析响应内容，返回值保存到HTTPData.Result
+type HTTPDecoder func(response *http.Response, body []byte) (interface{}, error)
+
+// ExpectStatus 响应状态码必须是codes之一，codes为空时要求2xx，否则返回*HTTPStatusError
+func ExpectStatus(codes ...int) HTTPValidator {
+	return func(response *http.Response, body []byte) error {
+		ok := len(codes) == 0 && response.StatusCode/100 == 2
+		for _, code := range codes {
+			if response.StatusCode == code {
+				ok = true
+			}
+		}
+		if !ok {
+			return newStatusError(response.Request, response.StatusCode, body)
+		}
+		return nil
+	}
+}
+
+// ExpectContentType 响应的Content-Type必须是mediaType，忽略charset等参数
+func ExpectContentType(mediaType string) HTTPValidator {
+	return func(response *http.Response, body []byte) error {
+		contentType := response.Header.Get("Content-Type")
+		if parsed, _, err := mime.ParseMediaType(contentType); err != nil || parsed != mediaType {
+			return fmt.Errorf("unexpected content type %q, want %q", contentType, mediaType)
+		}
+		return nil
+	}
+}
+
+// ValidateAll 依次执行所有检查，返回第一个错误
+func ValidateAll(validators ...HTTPValidator) HTTPValidator {
+	return func(response *http.Response, body []byte) error {
+		for _, validator := range validators {
+			if err := validator(response, body); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// JSONDecoder 每次调用newOut得到新的对象并把json响应解析进去，Result为newOut的返回值
+func JSONDecoder(newOut func() interface{}) HTTPDecoder {
+	return func(response *http.Response, body []byte) (interface{}, error) {
+		out := newOut()
+		if len(body) == 0 {
+			return out, nil
+		}
+		if err := json.Unmarshal(body, out); err != nil {
+			return nil, err
+		}
+		return out, nil
+	}
+}
+
+// needDecode 设置了Validate或者Decode并且有响应，流式请求不解析
+func (d *HTTPData) needDecode() bool {
+	return (d.Validate != nil || d.Decode != nil) && !d.Stream
+}
+
+// decodeBody 读取body并依次执行Validate和Decode，Validate为nil时要求2xx。
+// body替换为内存中的拷贝，调用方仍然可以读取；超过maxSize时不解析，body保持可读。
+// Transport没有设置response.Request时使用request
+func decodeBody(httpData *HTTPData, request *http.Request, response *http.Response, maxSize int64) (interface{}, error) {
+	body, err := io.ReadAll(io.LimitReader(response.Body, maxSize+1))
+	if err != nil {
+		response.Body.Close()
+		response.Body = io.NopCloser(bytes.NewReader(body))
+		return nil, err
+	}
+	if int64(len(body)) > maxSize {
+		response.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), response.Body), response.Body}
+		return nil, errorResponseTooLarge
+	}
+	response.Body.Close()
+	response.Body = io.NopCloser(bytes.NewReader(body))
+	response.ContentLength = int64(len(body))
+	if response.Request == nil {
+		response.Request = request
+	}
+	return runDecode(httpData, response, body)
+}
+
+// runDecode 执行Validate和Decode，panic时只让这个请求失败
+func runDecode(httpData *HTTPData, response *http.Response, body []byte) (result interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			Log.Error("http decode panic: %v", r)
+			result, err = nil, fmt.Errorf("%w: %v", errorDecodePanic, r)
+		}
+	}()
+	validate := httpData.Validate
+	if validate == nil {
+		validate = ExpectStatus()
+	}
+	if err = validate(response, body); err != nil {
+		return nil, err
+	}
+	if httpData.Decode == nil {
+		return nil, nil
+	}
+	return httpData.Decode(response, body)
+}
+
+// decodeResult 没有在worker中解析的响应(缓存、合并请求、降级等)在调用方解析
+func (cp *HTTPConnectionPool) decodeResult(httpData *HTTPData) {
+	if httpData.decoded || httpData.Err != nil || httpData.Response == nil || !httpData.needDecode() {
+		return
+	}
+	httpData.Result, httpData.DecodeErr = decodeBody(httpData, httpData.Request, httpData.Response, cp.getMaxResponseSize())
+	httpData.decoded = true
+}
@@ -0,0 +1,174 @@
+package goutils
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+type decodeUser struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+func newUserDecoder() HTTPDecoder {
+	return JSONDecoder(func() interface{} { return new(decodeUser) })
+}
+
+func Test_HTTPDecode(t *testing.T) {
+	upstream := NewMockUpstream()
+	defer upstream.Close()
+	jsonHeader := http.Header{"Content-Type": {"application/json; charset=utf-8"}}
+	upstream.Script("/user", MockResponse{Header: jsonHeader, Body: `{"id":1,"name":"a"}`})
+	upstream.Script("/broken", MockResponse{Header: jsonHeader, Body: `{"id":`})
+	upstream.Script("/missing", MockResponse{Status: http.StatusNotFound, Body: "not found"})
+	upstream.Script("/text", MockResponse{Body: "hello"})
+	pool := NewHTTPConnectionPoolWithOptions(WithTimeout(time.Second), WithPoolNum(4))
+	defer pool.Close()
+
+	validate := ValidateAll(ExpectStatus(), ExpectContentType("application/json"))
+	httpDatas := make([]*HTTPData, 0, 4)
+	for _, path := range []string{"/user", "/broken", "/missing", "/text"} {
+		httpData := NewHTTPData(newGetRequest(upstream.URL + path))
+		httpData.Validate = validate
+		httpData.Decode = newUserDecoder()
+		httpDatas = append(httpDatas, httpData)
+	}
+	pool.BatchRequest(httpDatas)
+	for _, httpData := range httpDatas {
+		if httpData.Err != nil {
+			t.Fatal(httpData.Err.Error())
+		}
+	}
+	if user, ok := httpDatas[0].Result.(*decodeUser); !ok || httpDatas[0].DecodeErr != nil || user.ID != 1 || user.Name != "a" {
+		t.Errorf("unexpected result %v %v", httpDatas[0].Result, httpDatas[0].DecodeErr)
+	}
+	// 解析后body仍然可读
+	body, _ := io.ReadAll(httpDatas[0].Response.Body)
+	if string(body) != `{"id":1,"name":"a"}` {
+		t.Errorf("body not preserved: %q", body)
+	}
+	if httpDatas[1].Result != nil || httpDatas[1].DecodeErr == nil {
+		t.Error("broken json should fail to decode")
+	}
+	if statusErr, ok := httpDatas[2].DecodeErr.(*HTTPStatusError); !ok || statusErr.StatusCode != http.StatusNotFound {
+		t.Errorf("expected status error, got %v", httpDatas[2].DecodeErr)
+	}
+	if httpDatas[3].DecodeErr == nil || !strings.Contains(httpDatas[3].DecodeErr.Error(), "content type") {
+		t.Errorf("expected content type error, got %v", httpDatas[3].DecodeErr)
+	}
+	for _, httpData := range httpDatas {
+		CloseResponse(httpData.Response)
+	}
+}
+
+func Test_HTTPDecodeOutsideWorker(t *testing.T) {
+	upstream := NewMockUpstream()
+	defer upstream.Close()
+	upstream.SetDefault(MockResponse{Body: `{"id":2,"name":"b"}`, Delay: 20 * time.Millisecond})
+	pool := NewHTTPConnectionPoolWithOptions(WithTimeout(time.Second), WithPoolNum(4), WithCoalesce(HTTPCoalesce{}))
+	defer pool.Close()
+
+	// 合并到其他请求的结果在调用方解析
+	httpDatas := make([]*HTTPData, 3)
+	for i := range httpDatas {
+		httpDatas[i] = NewHTTPData(newGetRequest(upstream.URL + "/user"))
+		httpDatas[i].Decode = newUserDecoder()
+	}
+	pool.BatchRequest(httpDatas)
+	for _, httpData := range httpDatas {
+		if user, ok := httpData.Result.(*decodeUser); !ok || user.ID != 2 {
+			t.Errorf("unexpected result %v %v %v", httpData.Result, httpData.DecodeErr, httpData.Err)
+		}
+		CloseResponse(httpData.Response)
+	}
+	if upstream.Requests("/user") != 1 {
+		t.Errorf("expected coalesced requests, got %d", upstream.Requests("/user"))
+	}
+
+	// 降级的响应也会解析
+	pool.SetFallback(StaticFallback(http.StatusOK, nil, []byte(`{"id":3}`)))
+	httpData := NewHTTPData(newGetRequest(upstream.URL + "/user"))
+	httpData.Timeout = time.Millisecond
+	httpData.Decode = newUserDecoder()
+	pool.Do(httpData)
+	if user, ok := httpData.Result.(*decodeUser); !ok || httpData.FallbackFrom == nil || user.ID != 3 {
+		t.Errorf("fallback response not decoded: %v %v", httpData.Result, httpData.DecodeErr)
+	}
+	CloseResponse(httpData.Response)
+}
+
+func Test_HTTPDecodeTooLarge(t *testing.T) {
+	upstream := NewMockUpstream()
+	defer upstream.Close()
+	upstream.SetDefault(MockResponse{Body: strings.Repeat("x", 100)})
+	pool := NewHTTPConnectionPoolWithOptions(WithTimeout(time.Second), WithPoolNum(1), WithMaxResponseSize(10))
+	defer pool.Close()
+	httpData := NewHTTPData(newGetRequest(upstream.URL))
+	httpData.Decode = newUserDecoder()
+	pool.Do(httpData)
+	if httpData.DecodeErr != errorResponseTooLarge {
+		t.Errorf("expected too large error, got %v", httpData.DecodeErr)
+	}
+	body, _ := io.ReadAll(httpData.Response.Body)
+	httpData.Response.Body.Close()
+	if len(body) != 100 {
+		t.Errorf("body should stay readable, got %d bytes", len(body))
+	}
+}
+
+// bareTransport 返回固定响应，不设置response.Request
+type bareTransport struct {
+	status int
+	body   string
+}
+
+func (t *bareTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	return &http.Response{StatusCode: t.status, Header: make(http.Header), Body: io.NopCloser(strings.NewReader(t.body))}, nil
+}
+
+func Test_HTTPDecodeBareTransport(t *testing.T) {
+	pool := NewHTTPConnectionPoolWithOptions(WithTimeout(time.Second), WithPoolNum(1),
+		WithTransport(&bareTransport{status: http.StatusInternalServerError, body: "oops"}))
+	defer pool.Close()
+	httpData := NewHTTPData(newGetRequest("http://127.0.0.1/users/1"))
+	httpData.Decode = newUserDecoder()
+	if err := pool.Do(httpData); err != nil {
+		t.Fatal(err.Error())
+	}
+	CloseResponse(httpData.Response)
+	statusErr, ok := httpData.DecodeErr.(*HTTPStatusError)
+	if !ok || statusErr.StatusCode != http.StatusInternalServerError || statusErr.URL != "http://127.0.0.1/users/1" {
+		t.Errorf("DecodeErr:%v", httpData.DecodeErr)
+	}
+	if err := ExpectStatus()(&http.Response{StatusCode: http.StatusBadGateway}, nil); err == nil {
+		t.Error("expect status error")
+	}
+}
+
+func Test_HTTPDecodePanic(t *testing.T) {
+	pool := NewHTTPConnectionPoolWithOptions(WithTimeout(time.Second), WithPoolNum(1),
+		WithTransport(&bareTransport{status: http.StatusOK, body: "{}"}))
+	defer pool.Close()
+	httpData := NewHTTPData(newGetRequest("http://127.0.0.1/users/1"))
+	httpData.Decode = func(response *http.Response, body []byte) (interface{}, error) {
+		panic("bad decoder")
+	}
+	if err := pool.Do(httpData); err != nil {
+		t.Fatal(err.Error())
+	}
+	CloseResponse(httpData.Response)
+	if !errors.Is(httpData.DecodeErr, errorDecodePanic) {
+		t.Errorf("DecodeErr:%v", httpData.DecodeErr)
+	}
+	// worker没有因为panic退出
+	httpData = NewHTTPData(newGetRequest("http://127.0.0.1/users/1"))
+	httpData.Decode = newUserDecoder()
+	if err := pool.Do(httpData); err != nil || httpData.DecodeErr != nil {
+		t.Errorf("err:%v DecodeErr:%v", err, httpData.DecodeErr)
+	}
+	CloseResponse(httpData.Response)
+}
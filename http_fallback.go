@@ -0,0 +1,191 @@
+package goutils
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// fallbackErrors 触发降级的错误：连接池拒绝、超时，以及所有后端节点都被剔除(熔断)
+var fallbackErrors = []error{
+	errorRequestPoolFull,
+	errorRequestShed,
+	errorRequestQueueTimeout,
+	errorRequestRateLimited,
+	errorBulkheadFull,
+	errorRequestCallTimeout,
+	errorNoHealthyEndpoint,
+}
+
+// HTTPFallback 降级处理，err为触发降级的错误，返回值作为请求的结果
+type HTTPFallback func(httpData *HTTPData, err error) (*http.Response, error)
+
+func isFallbackError(err error) bool {
+	for _, target := range fallbackErrors {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetFallback 设置连接池的降级处理，HTTPData单独设置的Fallback优先，nil表示关闭
+func (cp *HTTPConnectionPool) SetFallback(fallback HTTPFallback) {
+	cp.lock.Lock()
+	cp.fallback = fallback
+	cp.lock.Unlock()
+}
+
+func (cp *HTTPConnectionPool) getFallback() HTTPFallback {
+	cp.lock.Lock()
+	defer cp.lock.Unlock()
+	return cp.fallback
+}
+
+// runFallback 请求被拒绝、超时或者熔断时执行降级处理，结果记录到统计和请求的ServerContext中
+func (cp *HTTPConnectionPool) runFallback(httpData *HTTPData) {
+	err := httpData.Err
+	if err == nil || !isFallbackError(err) {
+		return
+	}
+	fallback := httpData.Fallback
+	if fallback == nil {
+		fallback = cp.getFallback()
+	}
+	if fallback == nil {
+		return
+	}
+	atomic.AddInt64(&cp.stats.fallbackNum, 1)
+	httpData.FallbackFrom = err
+	httpData.Response, httpData.Err = fallback(httpData, err)
+	httpData.decoded = false
+	result := "ok"
+	if httpData.Err != nil {
+		result = redactURLError(httpData.Err, stripQuery).Error()
+	}
+	if sc := httpData.serverContext(); sc != nil && httpData.Request != nil {
+		sc.AddNotes("http_fallback", fmt.Sprintf("%s%s:%s:%s", httpData.Request.URL.Host, httpData.Request.URL.Path, redactURLError(err, stripQuery).Error(), result))
+	}
+}
+
+// StaticFallback 返回固定的响应
+func StaticFallback(status int, header http.Header, body []byte) HTTPFallback {
+	return func(httpData *HTTPData, err error) (*http.Response, error) {
+		return newStaticResponse(httpData.Request, status, header, body), nil
+	}
+}
+
+// PoolFallback 用另一个连接池重新执行请求，请求有body时需要能通过GetBody重新读取
+func PoolFallback(pool *HTTPConnectionPool) HTTPFallback {
+	return func(httpData *HTTPData, err error) (*http.Response, error) {
+		request := httpData.Request
+		if request == nil {
+			return nil, err
+		}
+		if request.Body != nil && request.Body != http.NoBody {
+			if request.GetBody == nil {
+				return nil, err
+			}
+			body, bodyErr := request.GetBody()
+			if bodyErr != nil {
+				return nil, err
+			}
+			request = request.Clone(request.Context())
+			request.Body = body
+		}
+		return pool.Request(request.WithContext(httpData.requestContext()))
+	}
+}
+
+func newStaticResponse(request *http.Request, status int, header http.Header, body []byte) *http.Response {
+	header = header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       request,
+	}
+}
+
+// HTTPLastGood 记录每个GET请求最近一次成功的响应，降级时返回
+// 通过Interceptor记录响应，Fallback返回记录的响应
+type HTTPLastGood struct {
+	lock    *sync.Mutex
+	maxSize int64
+	entries map[string]*lastGoodEntry
+}
+
+type lastGoodEntry struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+// NewHTTPLastGood maxSize为记录的body最大字节数，更大的响应不记录
+func NewHTTPLastGood(maxSize int64) *HTTPLastGood {
+	if maxSize <= 0 {
+		maxSize = defaultCacheMaxEntrySize
+	}
+	return &HTTPLastGood{lock: new(sync.Mutex), maxSize: maxSize, entries: make(map[string]*lastGoodEntry)}
+}
+
+// lastGoodKey 使用后端节点时按改写前的URL记录，凭证不同的请求分开记录
+func lastGoodKey(request *http.Request) string {
+	return request.Method + " " + originalURL(request).String() + credentialKey(request)
+}
+
+// Interceptor 记录2xx响应的拦截器，通过Use注册
+func (l *HTTPLastGood) Interceptor() Interceptor {
+	return func(next RoundTripFunc) RoundTripFunc {
+		return func(request *http.Request) (*http.Response, error) {
+			response, err := next(request)
+			if err != nil || request.Method != "GET" || response.StatusCode/100 != 2 || response.ContentLength > l.maxSize {
+				return response, err
+			}
+			data, err := io.ReadAll(io.LimitReader(response.Body, l.maxSize+1))
+			if err != nil {
+				response.Body.Close()
+				return nil, err
+			}
+			if int64(len(data)) > l.maxSize {
+				response.Body = struct {
+					io.Reader
+					io.Closer
+				}{io.MultiReader(bytes.NewReader(data), response.Body), response.Body}
+				return response, nil
+			}
+			response.Body.Close()
+			response.Body = io.NopCloser(bytes.NewReader(data))
+			l.lock.Lock()
+			l.entries[lastGoodKey(request)] = &lastGoodEntry{status: response.StatusCode, header: response.Header.Clone(), body: data}
+			l.lock.Unlock()
+			return response, nil
+		}
+	}
+}
+
+// Fallback 返回记录的响应，没有记录时返回原始错误
+func (l *HTTPLastGood) Fallback(httpData *HTTPData, err error) (*http.Response, error) {
+	if httpData.Request == nil {
+		return nil, err
+	}
+	l.lock.Lock()
+	entry, ok := l.entries[lastGoodKey(httpData.Request)]
+	l.lock.Unlock()
+	if !ok {
+		return nil, err
+	}
+	return newStaticResponse(httpData.Request, entry.status, entry.header, entry.body), nil
+}
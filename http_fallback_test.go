@@ -0,0 +1,160 @@
+package goutils
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_HTTPStaticFallback(t *testing.T) {
+	upstream := NewMockUpstream()
+	defer upstream.Close()
+	pool := NewHTTPConnectionPoolWithOptions(WithTimeout(time.Second), WithPoolNum(0),
+		WithFallback(StaticFallback(http.StatusOK, http.Header{"X-Fallback": {"1"}}, []byte("static"))))
+	defer pool.Close()
+	response, err := pool.Request(newGetRequest(upstream.URL))
+	if err != nil || response.Header.Get("X-Fallback") != "1" {
+		t.Fatalf("response:%v err:%v", response, err)
+	}
+	CloseResponse(response)
+
+	// 单个请求的降级处理优先，并记录到ServerContext
+	sc := NewContext("test")
+	request, _ := http.NewRequestWithContext(WithServerContext(context.Background(), sc), "GET", upstream.URL+"/item", nil)
+	httpData := NewHTTPData(request)
+	httpData.Fallback = StaticFallback(http.StatusNoContent, nil, nil)
+	if err := pool.Do(httpData); err != nil {
+		t.Fatal(err.Error())
+	}
+	if httpData.Response.StatusCode != http.StatusNoContent || httpData.FallbackFrom != errorRequestPoolFull {
+		t.Errorf("status:%d from:%v", httpData.Response.StatusCode, httpData.FallbackFrom)
+	}
+	if notes := sc.buf.String(); !strings.Contains(notes, "http_fallback="+upstream.Addr()+"/item:ERROR_HTTP_REQUEST_POOL_FULL:ok") {
+		t.Errorf("notes:%s", notes)
+	}
+
+	// 使用HTTPData指定的ServerContext
+	sc = NewContext("test")
+	httpData = NewHTTPData(newGetRequest(upstream.URL + "/explicit"))
+	httpData.ServerContext = sc
+	if err := pool.Do(httpData); err != nil {
+		t.Fatal(err.Error())
+	}
+	CloseResponse(httpData.Response)
+	if notes := sc.buf.String(); !strings.Contains(notes, "http_fallback="+upstream.Addr()+"/explicit:ERROR_HTTP_REQUEST_POOL_FULL:ok") {
+		t.Errorf("notes:%s", notes)
+	}
+	if stats := pool.loadStats(); stats.fallbackNum != 3 {
+		t.Errorf("fallbackNum:%d", stats.fallbackNum)
+	}
+}
+
+func Test_HTTPFallbackNotTriggered(t *testing.T) {
+	upstream := NewMockUpstream()
+	defer upstream.Close()
+	upstream.SetDefault(MockResponse{Drop: true})
+	var called int32
+	pool := NewHTTPConnectionPool(time.Second, 1)
+	defer pool.Close()
+	pool.SetFallback(func(httpData *HTTPData, err error) (*http.Response, error) {
+		atomic.AddInt32(&called, 1)
+		return nil, err
+	})
+	if _, err := pool.Request(newGetRequest(upstream.URL)); err == nil {
+		t.Error("expect error")
+	}
+	if atomic.LoadInt32(&called) != 0 {
+		t.Error("fallback should not run on transport error")
+	}
+}
+
+func Test_HTTPLastGoodFallback(t *testing.T) {
+	upstream := NewMockUpstream()
+	defer upstream.Close()
+	upstream.Script("/config", MockResponse{Body: "v1"}, MockResponse{Delay: 200 * time.Millisecond})
+	lastGood := NewHTTPLastGood(0)
+	pool := NewHTTPConnectionPool(50*time.Millisecond, 2)
+	defer pool.Close()
+	pool.Use(lastGood.Interceptor())
+	pool.SetFallback(lastGood.Fallback)
+	for i := 0; i < 2; i++ {
+		if body, err := requestBody(pool, upstream.URL+"/config"); err != nil || body != "v1" {
+			t.Errorf("request:%d body:%s err:%v", i, body, err)
+		}
+	}
+	upstream.Script("/unknown", MockResponse{Delay: 200 * time.Millisecond})
+	if _, err := pool.Request(newGetRequest(upstream.URL + "/unknown")); err != errorRequestCallTimeout {
+		t.Errorf("expect original error without record, err:%v", err)
+	}
+}
+
+func Test_HTTPLastGoodCredential(t *testing.T) {
+	upstream := NewMockUpstream()
+	defer upstream.Close()
+	upstream.Script("/user", MockResponse{Body: "alice"}, MockResponse{Delay: 200 * time.Millisecond})
+	lastGood := NewHTTPLastGood(0)
+	pool := NewHTTPConnectionPool(50*time.Millisecond, 2)
+	defer pool.Close()
+	pool.Use(lastGood.Interceptor())
+	pool.SetFallback(lastGood.Fallback)
+	request := func(authorization string) (string, error) {
+		request := newGetRequest(upstream.URL + "/user")
+		request.Header.Set("Authorization", authorization)
+		response, err := pool.Request(request)
+		if err != nil {
+			return "", err
+		}
+		defer response.Body.Close()
+		body, err := io.ReadAll(response.Body)
+		return string(body), err
+	}
+	if body, err := request("Bearer alice"); err != nil || body != "alice" {
+		t.Fatalf("body:%s err:%v", body, err)
+	}
+	if body, err := request("Bearer bob"); err != errorRequestCallTimeout {
+		t.Errorf("other user got body:%s err:%v", body, err)
+	}
+	if body, err := request("Bearer alice"); err != nil || body != "alice" {
+		t.Errorf("body:%s err:%v", body, err)
+	}
+}
+
+func Test_HTTPPoolFallbackOnBreakerOpen(t *testing.T) {
+	primary := NewMockUpstream()
+	defer primary.Close()
+	secondary := NewMockUpstream()
+	defer secondary.Close()
+	secondary.SetDefault(MockResponse{Body: "secondary"})
+	upstream, err := NewHTTPUpstream(NewStaticResolver(primary.Addr()), NewRoundRobinBalancer())
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer upstream.Close()
+	alternate := NewHTTPConnectionPool(time.Second, 2)
+	defer alternate.Close()
+	pool := NewHTTPConnectionPoolWithOptions(WithTimeout(time.Second), WithPoolNum(2), WithUpstream(upstream),
+		WithFallback(PoolFallback(alternate)))
+	defer pool.Close()
+	for _, ep := range upstream.Endpoints() {
+		atomic.StoreInt32(&ep.health.unhealthy, 1)
+	}
+	request := newGetRequest(secondary.URL)
+	var batch []*HTTPData
+	for i := 0; i < 2; i++ {
+		batch = append(batch, NewHTTPData(request))
+	}
+	pool.BatchRequest(batch)
+	for _, data := range batch {
+		if data.Err != nil || data.FallbackFrom != errorNoHealthyEndpoint {
+			t.Fatalf("err:%v from:%v", data.Err, data.FallbackFrom)
+		}
+		CloseResponse(data.Response)
+	}
+	if primary.Requests("/") != 0 || secondary.Requests("/") != 2 {
+		t.Errorf("primary:%d secondary:%d", primary.Requests("/"), secondary.Requests("/"))
+	}
+}
@@ -0,0 +1,190 @@
+package goutils
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var errorFanOutFailed = errors.New("ERROR_HTTP_FANOUT_FAILED")
+
+// HTTPResult 扇出请求中一个请求的结果，Index为请求在参数中的下标
+type HTTPResult struct {
+	Index int
+	Data  *HTTPData
+}
+
+// fanOut 并发执行请求，所有请求共用一个连接池超时时间，结果按完成顺序发送到channel，全部完成后关闭。
+// 每个请求在从自己Request的context派生、并且随ctx结束而取消的context中执行，不修改调用方的Request，
+// 响应的context在body关闭时释放。
+// 调用返回的cancel只取消还没有发送结果的请求，已经返回的响应不受影响
+func (cp *HTTPConnectionPool) fanOut(ctx context.Context, httpDatas []*HTTPData) (<-chan *HTTPResult, context.CancelFunc) {
+	deadline := time.Now().Add(cp.GetTimeout())
+	results := make(chan *HTTPResult, len(httpDatas))
+	wg := new(sync.WaitGroup)
+	lock := new(sync.Mutex)
+	pending := make([]context.CancelFunc, len(httpDatas))
+	for i, httpData := range httpDatas {
+		cp.prepare(httpData, deadline)
+		if httpData.deadline.After(deadline) {
+			httpData.deadline = deadline
+		}
+		cancel := fanOutContext(ctx, httpData)
+		pending[i] = cancel
+		wg.Add(1)
+		go func(i int, httpData *HTTPData, cancel context.CancelFunc) {
+			defer wg.Done()
+			cp.dispatch(httpData)
+			cp.runFallback(httpData)
+			cp.settle(httpData)
+			lock.Lock()
+			pending[i] = nil
+			lock.Unlock()
+			if httpData.Response != nil && httpData.Response.Body != nil {
+				httpData.Response.Body = &cancelBody{ReadCloser: httpData.Response.Body, cancel: cancel}
+			} else {
+				cancel()
+			}
+			results <- &HTTPResult{Index: i, Data: httpData}
+		}(i, httpData, cancel)
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+	return results, func() {
+		lock.Lock()
+		defer lock.Unlock()
+		for i, cancel := range pending {
+			if cancel != nil {
+				cancel()
+				pending[i] = nil
+			}
+		}
+	}
+}
+
+// fanOutContext 合并请求自己的context和扇出的ctx，请求的值、截止时间和取消都保留
+func fanOutContext(ctx context.Context, httpData *HTTPData) context.CancelFunc {
+	parent := ctx
+	if httpData.Request != nil {
+		parent = httpData.Request.Context()
+	}
+	itemCtx, cancel := context.WithCancel(parent)
+	stop := context.AfterFunc(ctx, cancel)
+	httpData.ctx = itemCtx
+	return func() {
+		stop()
+		cancel()
+	}
+}
+
+// succeeded 请求成功并且返回2xx
+func succeeded(httpData *HTTPData) bool {
+	return httpData.Err == nil && httpData.Response != nil && httpData.Response.StatusCode/100 == 2
+}
+
+// discardResults 目标达成后关闭剩余请求的响应
+func discardResults(results <-chan *HTTPResult) {
+	for result := range results {
+		CloseResponse(result.Data.Response)
+	}
+}
+
+// FanOut 并发执行请求，结果按完成顺序通过channel返回，全部完成后关闭channel。
+// 所有请求共用一个连接池超时时间，ctx结束时取消未完成的请求，调用方需要读完channel并关闭响应
+func (cp *HTTPConnectionPool) FanOut(ctx context.Context, httpDatas []*HTTPData) <-chan *HTTPResult {
+	results, _ := cp.fanOut(ctx, httpDatas)
+	return results
+}
+
+// AllSettled 并发执行所有请求并等待全部完成，结果保存在各自的HTTPData中，所有请求共用一个连接池超时时间
+func (cp *HTTPConnectionPool) AllSettled(ctx context.Context, httpDatas []*HTTPData) {
+	results, _ := cp.fanOut(ctx, httpDatas)
+	for range results {
+	}
+}
+
+// FirstSuccess 返回第一个成功(2xx)的请求并取消其余请求，其余请求的响应会被关闭。全部失败时返回错误
+func (cp *HTTPConnectionPool) FirstSuccess(ctx context.Context, httpDatas []*HTTPData) (*HTTPData, error) {
+	list, err := cp.FirstN(ctx, httpDatas, 1)
+	if err != nil {
+		return nil, err
+	}
+	return list[0], nil
+}
+
+// FirstN 返回最先成功(2xx)的n个请求并取消其余请求，其余请求的响应会被关闭。
+// 成功的请求不可能达到n个时立即返回错误
+func (cp *HTTPConnectionPool) FirstN(ctx context.Context, httpDatas []*HTTPData, n int) ([]*HTTPData, error) {
+	if n <= 0 || n > len(httpDatas) {
+		return nil, errorFanOutFailed
+	}
+	results, cancel := cp.fanOut(ctx, httpDatas)
+	defer func() { go discardResults(results) }()
+	defer cancel()
+	remain := len(httpDatas)
+	list := make([]*HTTPData, 0, n)
+	for result := range results {
+		remain--
+		if !succeeded(result.Data) {
+			CloseResponse(result.Data.Response)
+		} else if list = append(list, result.Data); len(list) == n {
+			return list, nil
+		}
+		if len(list)+remain < n {
+			break
+		}
+	}
+	for _, httpData := range list {
+		CloseResponse(httpData.Response)
+	}
+	return nil, errorFanOutFailed
+}
+
+// Quorum 等待超过半数的请求成功(2xx)并且key相同，返回这些请求并取消其余请求，其余请求的响应会被关闭。
+// key为nil时只要求成功，key需要读取body时应开启SetBufferedResponse或者自行缓冲
+func (cp *HTTPConnectionPool) Quorum(ctx context.Context, httpDatas []*HTTPData, key func(httpData *HTTPData) string) ([]*HTTPData, error) {
+	need := len(httpDatas)/2 + 1
+	results, cancel := cp.fanOut(ctx, httpDatas)
+	defer func() { go discardResults(results) }()
+	defer cancel()
+	remain := len(httpDatas)
+	groups := make(map[string][]*HTTPData)
+	largest := 0
+	for result := range results {
+		remain--
+		if succeeded(result.Data) {
+			k := ""
+			if key != nil {
+				k = key(result.Data)
+			}
+			groups[k] = append(groups[k], result.Data)
+			if len(groups[k]) == need {
+				for other, list := range groups {
+					if other != k {
+						for _, httpData := range list {
+							CloseResponse(httpData.Response)
+						}
+					}
+				}
+				return groups[k], nil
+			}
+			if len(groups[k]) > largest {
+				largest = len(groups[k])
+			}
+		} else {
+			CloseResponse(result.Data.Response)
+		}
+		if largest+remain < need {
+			break
+		}
+	}
+	for _, list := range groups {
+		for _, httpData := range list {
+			CloseResponse(httpData.Response)
+		}
+	}
+	return nil, errorFanOutFailed
+}
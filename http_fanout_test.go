@@ -0,0 +1,252 @@
+package goutils
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newFanOutUpstream() *MockUpstream {
+	upstream := NewMockUpstream()
+	upstream.Script("/fast", MockResponse{Body: "fast", Delay: 10 * time.Millisecond})
+	upstream.Script("/slow", MockResponse{Body: "slow", Delay: 300 * time.Millisecond})
+	upstream.Script("/fail", MockResponse{Status: http.StatusInternalServerError, Body: "fail"})
+	return upstream
+}
+
+func newFanOutData(url string) *HTTPData {
+	return NewHTTPData(newGetRequest(url))
+}
+
+func Test_HTTPFanOut(t *testing.T) {
+	upstream := newFanOutUpstream()
+	defer upstream.Close()
+	pool := NewHTTPConnectionPoolWithOptions(WithTimeout(time.Second), WithPoolNum(4))
+	defer pool.Close()
+	httpDatas := []*HTTPData{newFanOutData(upstream.URL + "/slow"), newFanOutData(upstream.URL + "/fast")}
+	var order []int
+	for result := range pool.FanOut(context.Background(), httpDatas) {
+		order = append(order, result.Index)
+		CloseResponse(result.Data.Response)
+	}
+	if len(order) != 2 || order[0] != 1 || order[1] != 0 {
+		t.Errorf("unexpected completion order %v", order)
+	}
+}
+
+func Test_HTTPAllSettled(t *testing.T) {
+	upstream := newFanOutUpstream()
+	defer upstream.Close()
+	pool := NewHTTPConnectionPoolWithOptions(WithTimeout(100*time.Millisecond), WithPoolNum(4))
+	defer pool.Close()
+	httpDatas := []*HTTPData{newFanOutData(upstream.URL + "/fast"), newFanOutData(upstream.URL + "/slow"), newFanOutData(upstream.URL + "/fail")}
+	start := time.Now()
+	pool.AllSettled(context.Background(), httpDatas)
+	if elapsed := time.Since(start); elapsed > 250*time.Millisecond {
+		t.Errorf("all settled exceeded the shared deadline: %v", elapsed)
+	}
+	if httpDatas[0].Err != nil || httpDatas[0].Response.StatusCode != http.StatusOK {
+		t.Errorf("fast request failed: %v", httpDatas[0].Err)
+	}
+	if httpDatas[1].Err == nil {
+		t.Error("slow request should time out")
+	}
+	if httpDatas[2].Err != nil || httpDatas[2].Response.StatusCode != http.StatusInternalServerError {
+		t.Errorf("failing request returned %v", httpDatas[2].Err)
+	}
+	for _, httpData := range httpDatas {
+		CloseResponse(httpData.Response)
+	}
+}
+
+func Test_HTTPFirstSuccess(t *testing.T) {
+	upstream := newFanOutUpstream()
+	defer upstream.Close()
+	pool := NewHTTPConnectionPoolWithOptions(WithTimeout(time.Second), WithPoolNum(4))
+	defer pool.Close()
+	httpDatas := []*HTTPData{newFanOutData(upstream.URL + "/fail"), newFanOutData(upstream.URL + "/slow"), newFanOutData(upstream.URL + "/fast")}
+	start := time.Now()
+	httpData, err := pool.FirstSuccess(context.Background(), httpDatas)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	body, _ := io.ReadAll(httpData.Response.Body)
+	httpData.Response.Body.Close()
+	if string(body) != "fast" {
+		t.Errorf("unexpected winner %q", body)
+	}
+	if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+		t.Errorf("first success waited for slow request: %v", elapsed)
+	}
+
+	_, err = pool.FirstSuccess(context.Background(), []*HTTPData{newFanOutData(upstream.URL + "/fail")})
+	if err != errorFanOutFailed {
+		t.Errorf("expected fan-out failure, got %v", err)
+	}
+}
+
+func Test_HTTPFirstN(t *testing.T) {
+	upstream := newFanOutUpstream()
+	defer upstream.Close()
+	pool := NewHTTPConnectionPoolWithOptions(WithTimeout(time.Second), WithPoolNum(4))
+	defer pool.Close()
+	httpDatas := []*HTTPData{newFanOutData(upstream.URL + "/fast"), newFanOutData(upstream.URL + "/slow"), newFanOutData(upstream.URL + "/fast")}
+	start := time.Now()
+	list, err := pool.FirstN(context.Background(), httpDatas, 2)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(list) != 2 || list[0] == httpDatas[1] || list[1] == httpDatas[1] {
+		t.Error("first n should return the fast requests")
+	}
+	for _, httpData := range list {
+		CloseResponse(httpData.Response)
+	}
+	if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+		t.Errorf("first n waited for slow request: %v", elapsed)
+	}
+
+	start = time.Now()
+	httpDatas = []*HTTPData{newFanOutData(upstream.URL + "/fail"), newFanOutData(upstream.URL + "/fail"), newFanOutData(upstream.URL + "/slow")}
+	if _, err = pool.FirstN(context.Background(), httpDatas, 2); err != errorFanOutFailed {
+		t.Errorf("expected fan-out failure, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+		t.Errorf("first n should fail early: %v", elapsed)
+	}
+}
+
+func Test_HTTPQuorum(t *testing.T) {
+	upstream := newFanOutUpstream()
+	defer upstream.Close()
+	upstream.Script("/a", MockResponse{Header: http.Header{"X-Version": {"1"}}})
+	upstream.Script("/b", MockResponse{Header: http.Header{"X-Version": {"2"}}, Delay: 20 * time.Millisecond})
+	upstream.Script("/c", MockResponse{Header: http.Header{"X-Version": {"1"}}, Delay: 50 * time.Millisecond})
+	pool := NewHTTPConnectionPoolWithOptions(WithTimeout(time.Second), WithPoolNum(4))
+	defer pool.Close()
+	version := func(httpData *HTTPData) string {
+		return httpData.Response.Header.Get("X-Version")
+	}
+	httpDatas := []*HTTPData{newFanOutData(upstream.URL + "/a"), newFanOutData(upstream.URL + "/b"), newFanOutData(upstream.URL + "/c")}
+	list, err := pool.Quorum(context.Background(), httpDatas, version)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(list) != 2 || version(list[0]) != "1" || version(list[1]) != "1" {
+		t.Error("quorum should agree on version 1")
+	}
+	for _, httpData := range list {
+		CloseResponse(httpData.Response)
+	}
+
+	httpDatas = []*HTTPData{newFanOutData(upstream.URL + "/a"), newFanOutData(upstream.URL + "/b"), newFanOutData(upstream.URL + "/fail")}
+	if _, err = pool.Quorum(context.Background(), httpDatas, version); err != errorFanOutFailed {
+		t.Errorf("expected fan-out failure, got %v", err)
+	}
+}
+
+func Test_HTTPFanOutCancel(t *testing.T) {
+	upstream := newFanOutUpstream()
+	defer upstream.Close()
+	pool := NewHTTPConnectionPoolWithOptions(WithTimeout(time.Second), WithPoolNum(4))
+	defer pool.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	httpDatas := []*HTTPData{newFanOutData(upstream.URL + "/slow")}
+	pool.AllSettled(ctx, httpDatas)
+	if httpDatas[0].Err == nil {
+		t.Error("cancelled request should fail")
+	}
+	if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+		t.Errorf("cancel did not abort request: %v", elapsed)
+	}
+}
+
+func Test_HTTPFanOutRequestContext(t *testing.T) {
+	upstream := newFanOutUpstream()
+	defer upstream.Close()
+	pool := NewHTTPConnectionPoolWithOptions(WithTimeout(time.Second), WithPoolNum(4))
+	defer pool.Close()
+	var found int32
+	pool.Use(func(next RoundTripFunc) RoundTripFunc {
+		return func(request *http.Request) (*http.Response, error) {
+			if ServerContextFrom(request.Context()) != nil {
+				atomic.AddInt32(&found, 1)
+			}
+			return next(request)
+		}
+	})
+	sc := NewContext("fanout")
+	ctx, cancel := context.WithCancel(WithServerContext(context.Background(), sc))
+	time.AfterFunc(50*time.Millisecond, cancel)
+	fast, slow := newFanOutData(upstream.URL+"/fast"), newFanOutData(upstream.URL+"/slow")
+	fast.Request, slow.Request = fast.Request.WithContext(ctx), slow.Request.WithContext(ctx)
+	start := time.Now()
+	pool.AllSettled(context.Background(), []*HTTPData{fast, slow})
+	CloseResponse(fast.Response)
+	if fast.Err != nil || atomic.LoadInt32(&found) != 2 {
+		t.Errorf("err:%v found:%d", fast.Err, found)
+	}
+	if slow.Err != context.Canceled || time.Since(start) > 200*time.Millisecond {
+		t.Errorf("request context not honoured: err:%v cost:%v", slow.Err, time.Since(start))
+	}
+}
+
+// newTrickleServer 先返回10个字节，50ms后再返回剩余部分
+func newTrickleServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("0123456789"))
+		w.(http.Flusher).Flush()
+		time.Sleep(50 * time.Millisecond)
+		w.Write([]byte("abcdefghij"))
+	}))
+}
+
+func readFanOutBody(t *testing.T, httpData *HTTPData) {
+	body, err := io.ReadAll(httpData.Response.Body)
+	httpData.Response.Body.Close()
+	if err != nil || string(body) != "0123456789abcdefghij" {
+		t.Errorf("body:%q error:%v", body, err)
+	}
+}
+
+func Test_HTTPFanOutReadBody(t *testing.T) {
+	server := newTrickleServer()
+	defer server.Close()
+	pool := NewHTTPConnectionPoolWithOptions(WithTimeout(time.Second), WithPoolNum(4))
+	defer pool.Close()
+	newDatas := func() ([]*HTTPData, []*http.Request) {
+		httpDatas := []*HTTPData{newFanOutData(server.URL), newFanOutData(server.URL)}
+		return httpDatas, []*http.Request{httpDatas[0].Request, httpDatas[1].Request}
+	}
+
+	httpDatas, requests := newDatas()
+	pool.AllSettled(context.Background(), httpDatas)
+	for i, httpData := range httpDatas {
+		if httpData.Request != requests[i] {
+			t.Error("caller request replaced")
+		}
+		readFanOutBody(t, httpData)
+	}
+
+	httpDatas, _ = newDatas()
+	httpData, err := pool.FirstSuccess(context.Background(), httpDatas)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	readFanOutBody(t, httpData)
+
+	httpDatas, _ = newDatas()
+	list, err := pool.FirstN(context.Background(), httpDatas, 2)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	for _, httpData := range list {
+		readFanOutBody(t, httpData)
+	}
+}
@@ -0,0 +1,240 @@
+package goutils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var errorFaultDropped = errors.New("ERROR_HTTP_FAULT_CONNECTION_DROPPED")
+
+// HTTPFault 故障注入规则，按Host和PathPrefix匹配请求，匹配的请求中Percent比例的触发故障。
+// Error、Drop、Status会直接返回，不发出真实请求；Delay在发出请求前等待；Truncate截断响应body
+type HTTPFault struct {
+	Host       string        //为空匹配所有host，可以是host或者host:port
+	PathPrefix string        //为空匹配所有path
+	Percent    float64       //触发比例，0-100
+	Delay      time.Duration //增加的延迟
+	Error      string        //返回的错误信息
+	Drop       bool          //模拟连接断开
+	Status     int           //返回的状态码
+	Truncate   int64         //响应body读取这么多字节后返回io.ErrUnexpectedEOF，0表示不截断
+}
+
+// httpFaultJSON 配置文件格式，Delay为"100ms"这样的字符串
+type httpFaultJSON struct {
+	Host       string  `json:"host"`
+	PathPrefix string  `json:"path_prefix"`
+	Percent    float64 `json:"percent"`
+	Delay      string  `json:"delay"`
+	Error      string  `json:"error"`
+	Drop       bool    `json:"drop"`
+	Status     int     `json:"status"`
+	Truncate   int64   `json:"truncate"`
+}
+
+// UnmarshalJSON 解析配置文件中的规则
+func (f *HTTPFault) UnmarshalJSON(data []byte) error {
+	var v httpFaultJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*f = HTTPFault{Host: v.Host, PathPrefix: v.PathPrefix, Percent: v.Percent, Error: v.Error, Drop: v.Drop, Status: v.Status, Truncate: v.Truncate}
+	if v.Delay != "" {
+		delay, err := time.ParseDuration(v.Delay)
+		if err != nil {
+			return fmt.Errorf("invalid fault delay: %s", v.Delay)
+		}
+		f.Delay = delay
+	}
+	return nil
+}
+
+func (f *HTTPFault) match(request *http.Request) bool {
+	if f.Host != "" && f.Host != request.URL.Host && f.Host != request.URL.Hostname() {
+		return false
+	}
+	return strings.HasPrefix(request.URL.Path, f.PathPrefix)
+}
+
+// HTTPFaultInjector 故障注入，用于演练超时、降级等处理是否生效
+type HTTPFaultInjector struct {
+	lock        *sync.Mutex
+	faults      []HTTPFault
+	enabled     bool
+	path        string
+	injectedNum int64
+}
+
+// NewHTTPFaultInjector 使用给定规则构造，默认开启
+func NewHTTPFaultInjector(faults ...HTTPFault) *HTTPFaultInjector {
+	return &HTTPFaultInjector{lock: new(sync.Mutex), faults: faults, enabled: true}
+}
+
+// NewFileFaultInjector 从json文件读取配置，格式为{"enabled": true, "faults": [{"host": "", "path_prefix": "/api", "percent": 10, "delay": "100ms"}]}，
+// Reload时重新读取
+func NewFileFaultInjector(path string) (*HTTPFaultInjector, error) {
+	f := &HTTPFaultInjector{lock: new(sync.Mutex), path: path}
+	if err := f.load(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *HTTPFaultInjector) load() error {
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return err
+	}
+	var config struct {
+		Enabled bool        `json:"enabled"`
+		Faults  []HTTPFault `json:"faults"`
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return err
+	}
+	f.lock.Lock()
+	f.enabled, f.faults = config.Enabled, config.Faults
+	f.lock.Unlock()
+	return nil
+}
+
+// Reload 重新读取配置文件，实现了SignalReload接口，读取失败时保留原配置
+func (f *HTTPFaultInjector) Reload() {
+	if f.path == "" {
+		return
+	}
+	if err := f.load(); err != nil {
+		Log.Error("reload fault injector config:%s error:%s", f.path, err.Error())
+		return
+	}
+	Log.Notice("reload fault injector config:%s, enabled:%v", f.path, f.Enabled())
+}
+
+// SetFaults 替换故障规则
+func (f *HTTPFaultInjector) SetFaults(faults ...HTTPFault) {
+	f.lock.Lock()
+	f.faults = faults
+	f.lock.Unlock()
+}
+
+// Enable 开启故障注入
+func (f *HTTPFaultInjector) Enable() {
+	f.lock.Lock()
+	f.enabled = true
+	f.lock.Unlock()
+}
+
+// Disable 关闭故障注入，规则保留
+func (f *HTTPFaultInjector) Disable() {
+	f.lock.Lock()
+	f.enabled = false
+	f.lock.Unlock()
+}
+
+// Enabled 是否开启
+func (f *HTTPFaultInjector) Enabled() bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.enabled
+}
+
+// InjectedNum 已经注入故障的请求数
+func (f *HTTPFaultInjector) InjectedNum() int64 {
+	return atomic.LoadInt64(&f.injectedNum)
+}
+
+// pick 按顺序检查匹配的规则，返回第一个触发的规则
+func (f *HTTPFaultInjector) pick(request *http.Request) (HTTPFault, bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if !f.enabled {
+		return HTTPFault{}, false
+	}
+	for _, fault := range f.faults {
+		if fault.match(request) && rand.Float64()*100 < fault.Percent {
+			return fault, true
+		}
+	}
+	return HTTPFault{}, false
+}
+
+// inject 对触发规则的请求注入故障，否则直接调用next
+func (f *HTTPFaultInjector) inject(request *http.Request, next RoundTripFunc) (*http.Response, error) {
+	fault, ok := f.pick(request)
+	if !ok {
+		return next(request)
+	}
+	atomic.AddInt64(&f.injectedNum, 1)
+	if fault.Delay > 0 {
+		timer := time.NewTimer(fault.Delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-request.Context().Done():
+			return nil, request.Context().Err()
+		}
+	}
+	switch {
+	case fault.Error != "":
+		return nil, errors.New(fault.Error)
+	case fault.Drop:
+		return nil, errorFaultDropped
+	case fault.Status > 0:
+		return &http.Response{
+			Status:     fmt.Sprintf("%d %s", fault.Status, http.StatusText(fault.Status)),
+			StatusCode: fault.Status,
+			Proto:      "HTTP/1.1",
+			ProtoMajor: 1,
+			ProtoMinor: 1,
+			Header:     http.Header{"X-Fault-Injected": {"1"}},
+			Body:       http.NoBody,
+			Request:    request,
+		}, nil
+	}
+	response, err := next(request)
+	if err == nil && fault.Truncate > 0 {
+		response.Body = &truncatedBody{ReadCloser: response.Body, remain: fault.Truncate}
+	}
+	return response, err
+}
+
+// truncatedBody 读取remain字节后返回io.ErrUnexpectedEOF
+type truncatedBody struct {
+	io.ReadCloser
+	remain int64
+}
+
+func (b *truncatedBody) Read(p []byte) (int, error) {
+	if b.remain <= 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	if int64(len(p)) > b.remain {
+		p = p[:b.remain]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.remain -= int64(n)
+	return n, err
+}
+
+// SetFaultInjector 设置故障注入，nil表示关闭。故障在选出后端节点和执行拦截器之后注入，
+// 对连接池来说和后端真实出错一样，会计入超时和节点异常统计
+func (cp *HTTPConnectionPool) SetFaultInjector(f *HTTPFaultInjector) {
+	cp.lock.Lock()
+	cp.faults = f
+	cp.lock.Unlock()
+}
+
+func (cp *HTTPConnectionPool) getFaultInjector() *HTTPFaultInjector {
+	cp.lock.Lock()
+	defer cp.lock.Unlock()
+	return cp.faults
+}
@@ -0,0 +1,102 @@
+package goutils
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_HTTPFaultStatusAndError(t *testing.T) {
+	upstream := NewMockUpstream()
+	defer upstream.Close()
+	faults := NewHTTPFaultInjector(
+		HTTPFault{PathPrefix: "/status", Percent: 100, Status: http.StatusServiceUnavailable},
+		HTTPFault{PathPrefix: "/error", Percent: 100, Error: "injected error"},
+		HTTPFault{PathPrefix: "/drop", Percent: 100, Drop: true},
+		HTTPFault{PathPrefix: "/never", Percent: 0, Drop: true},
+	)
+	pool := NewHTTPConnectionPoolWithOptions(WithTimeout(time.Second), WithPoolNum(2), WithFaultInjector(faults))
+	defer pool.Close()
+	response, err := pool.Request(newGetRequest(upstream.URL + "/status"))
+	if err != nil || response.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("response:%v err:%v", response, err)
+	}
+	CloseResponse(response)
+	if _, err := pool.Request(newGetRequest(upstream.URL + "/error")); err == nil || err.Error() != "injected error" {
+		t.Errorf("err:%v", err)
+	}
+	if _, err := pool.Request(newGetRequest(upstream.URL + "/drop")); err == nil {
+		t.Error("expect dropped")
+	}
+	if _, err := requestBody(pool, upstream.URL+"/never"); err != nil {
+		t.Errorf("err:%v", err)
+	}
+	if upstream.Requests("/status") != 0 || upstream.Requests("/never") != 1 {
+		t.Errorf("requests status:%d never:%d", upstream.Requests("/status"), upstream.Requests("/never"))
+	}
+	if faults.InjectedNum() != 3 {
+		t.Errorf("injected:%d", faults.InjectedNum())
+	}
+	// 关闭后请求正常发出
+	faults.Disable()
+	response, err = pool.Request(newGetRequest(upstream.URL + "/status"))
+	if err != nil || response.StatusCode != http.StatusOK {
+		t.Errorf("response:%v err:%v", response, err)
+	}
+	CloseResponse(response)
+}
+
+func Test_HTTPFaultDelayAndTruncate(t *testing.T) {
+	upstream := NewMockUpstream()
+	defer upstream.Close()
+	upstream.SetDefault(MockResponse{Body: "0123456789"})
+	faults := NewHTTPFaultInjector(
+		HTTPFault{Host: upstream.Addr(), PathPrefix: "/slow", Percent: 100, Delay: 200 * time.Millisecond},
+		HTTPFault{Host: "127.0.0.1", PathPrefix: "/truncate", Percent: 100, Truncate: 4},
+		HTTPFault{Host: "other.host", Percent: 100, Drop: true},
+	)
+	pool := NewHTTPConnectionPool(50*time.Millisecond, 2)
+	defer pool.Close()
+	pool.SetFaultInjector(faults)
+	if _, err := pool.Request(newGetRequest(upstream.URL + "/slow")); err != errorRequestCallTimeout {
+		t.Errorf("err:%v", err)
+	}
+	response, err := pool.Request(newGetRequest(upstream.URL + "/truncate"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	body, err := io.ReadAll(response.Body)
+	response.Body.Close()
+	if err != io.ErrUnexpectedEOF || string(body) != "0123" {
+		t.Errorf("body:%s err:%v", body, err)
+	}
+	if body, err := requestBody(pool, upstream.URL+"/ok"); err != nil || body != "0123456789" {
+		t.Errorf("body:%s err:%v", body, err)
+	}
+}
+
+func Test_HTTPFaultReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "faults.json")
+	os.WriteFile(path, []byte(`{"enabled": false, "faults": [{"path_prefix": "/", "percent": 100, "status": 500, "delay": "1ms"}]}`), 0644)
+	faults, err := NewFileFaultInjector(path)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if faults.Enabled() || len(faults.faults) != 1 || faults.faults[0].Delay != time.Millisecond {
+		t.Errorf("faults:%+v", faults.faults)
+	}
+	var reload SignalReload = faults
+	os.WriteFile(path, []byte(`{"enabled": true, "faults": []}`), 0644)
+	reload.Reload()
+	if !faults.Enabled() || len(faults.faults) != 0 {
+		t.Errorf("enabled:%v faults:%+v", faults.Enabled(), faults.faults)
+	}
+	os.WriteFile(path, []byte(`{"faults": [{"delay": "soon"}]}`), 0644)
+	reload.Reload()
+	if !faults.Enabled() {
+		t.Error("expect old config kept")
+	}
+}
@@ -0,0 +1,163 @@
+package goutils
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	defaultHealthPath               = "/"
+	defaultHealthInterval           = 10 * time.Second
+	defaultHealthTimeout            = time.Second
+	defaultHealthyThreshold   int64 = 2
+	defaultUnhealthyThreshold int64 = 3
+)
+
+// HTTPHealthCheck 主动健康检查配置
+type HTTPHealthCheck struct {
+	Path               string        //检查路径，默认"/"，节点设置了HealthPath时以节点的为准
+	Interval           time.Duration //检查间隔，默认10s
+	Timeout            time.Duration //单次检查超时时间，默认1s
+	HealthyThreshold   int64         //连续成功多少次恢复，默认2
+	UnhealthyThreshold int64         //连续失败多少次下线，默认3
+	ExpectedStatus     int           //期望的状态码，0表示任意2xx
+	//检查使用的Transport，nil时使用http.DefaultTransport，
+	//传入HTTPConnectionPool.Transport()可以使用连接池的TLS、unix socket和DNS配置
+	Transport http.RoundTripper
+}
+
+// httpHealth 节点健康状态，只由健康检查协程修改
+type httpHealth struct {
+	unhealthy int32
+	okNum     int64 //连续成功次数
+	failNum   int64 //连续失败次数
+}
+
+// Healthy 主动健康检查的结果，未开启健康检查时总是true
+func (ep *HTTPEndpoint) Healthy() bool {
+	return atomic.LoadInt32(&ep.health.unhealthy) == 0
+}
+
+// StartHealthCheck 开启后台健康检查，不健康的节点不参与负载均衡，Close时停止
+// 重复调用时停止之前的检查，使用新配置重新开始
+func (u *HTTPUpstream) StartHealthCheck(check HTTPHealthCheck) {
+	if len(check.Path) == 0 {
+		check.Path = defaultHealthPath
+	}
+	if check.Interval <= 0 {
+		check.Interval = defaultHealthInterval
+	}
+	if check.Timeout <= 0 {
+		check.Timeout = defaultHealthTimeout
+	}
+	if check.HealthyThreshold <= 0 {
+		check.HealthyThreshold = defaultHealthyThreshold
+	}
+	if check.UnhealthyThreshold <= 0 {
+		check.UnhealthyThreshold = defaultUnhealthyThreshold
+	}
+	client := &http.Client{Timeout: check.Timeout, Transport: check.Transport}
+	stop := make(chan bool)
+	u.lock.Lock()
+	if u.healthStop != nil {
+		close(u.healthStop)
+	}
+	u.healthStop = stop
+	u.lock.Unlock()
+	go func() {
+		ticker := time.NewTicker(check.Interval)
+		defer ticker.Stop()
+		for {
+			u.checkHealth(client, check)
+			select {
+			case <-ticker.C:
+			case <-stop:
+				return
+			case <-u.quit:
+				return
+			}
+		}
+	}()
+}
+
+// checkHealth 并发检查所有节点
+func (u *HTTPUpstream) checkHealth(client *http.Client, check HTTPHealthCheck) {
+	wg := new(sync.WaitGroup)
+	for _, ep := range u.Endpoints() {
+		wg.Add(1)
+		go func(ep *HTTPEndpoint) {
+			defer wg.Done()
+			ep.updateHealth(ep.probe(client, check), check)
+		}(ep)
+	}
+	wg.Wait()
+}
+
+// probe 请求一次健康检查地址
+func (ep *HTTPEndpoint) probe(client *http.Client, check HTTPHealthCheck) error {
+	scheme := ep.Scheme
+	if len(scheme) == 0 {
+		scheme = "http"
+	}
+	path := check.Path
+	if len(ep.HealthPath) > 0 {
+		path = ep.HealthPath
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	response, err := client.Get(scheme + "://" + ep.Addr + path)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if check.ExpectedStatus == 0 && response.StatusCode/100 == 2 {
+		return nil
+	}
+	if response.StatusCode == check.ExpectedStatus {
+		return nil
+	}
+	return fmt.Errorf("unexpected status %d", response.StatusCode)
+}
+
+func (ep *HTTPEndpoint) updateHealth(err error, check HTTPHealthCheck) {
+	health := &ep.health
+	if err == nil {
+		atomic.StoreInt64(&health.failNum, 0)
+		if atomic.AddInt64(&health.okNum, 1) >= check.HealthyThreshold && !ep.Healthy() {
+			Log.Notice("upstream endpoint:%s is healthy", ep.Addr)
+			atomic.StoreInt32(&health.unhealthy, 0)
+		}
+		return
+	}
+	atomic.StoreInt64(&health.okNum, 0)
+	if atomic.AddInt64(&health.failNum, 1) >= check.UnhealthyThreshold && ep.Healthy() {
+		Log.Warning("upstream endpoint:%s is unhealthy, err:%s", ep.Addr, err.Error())
+		atomic.StoreInt32(&health.unhealthy, 1)
+	}
+}
+
+// Status 节点状态，格式为 addr:state:outstanding，state为healthy、unhealthy或者ejected
+func (u *HTTPUpstream) Status() string {
+	endpoints := u.Endpoints()
+	states := make([]string, 0, len(endpoints))
+	for _, ep := range endpoints {
+		states = append(states, fmt.Sprintf("%s:%s:%d", ep.Addr, ep.state(), ep.Outstanding()))
+	}
+	return strings.Join(states, " ")
+}
+
+// state 节点状态，healthy、unhealthy或者ejected
+func (ep *HTTPEndpoint) state() string {
+	if !ep.Healthy() {
+		return "unhealthy"
+	}
+	if ep.Ejected() {
+		return "ejected"
+	}
+	return "healthy"
+}
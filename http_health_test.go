@@ -0,0 +1,102 @@
+package goutils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_HTTPHealthCheck(t *testing.T) {
+	var healthy int32 = 1
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" || atomic.LoadInt32(&healthy) == 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+	other := newNamedServer("other", 200)
+	defer other.Close()
+	upstream, _ := NewHTTPUpstream(NewStaticResolver(serverAddr(server), serverAddr(other)), nil)
+	upstream.Endpoints()[1].HealthPath = "/"
+	pool := NewHTTPConnectionPoolWithOptions(WithTimeout(time.Second), WithPoolNum(1), WithUpstream(upstream))
+	defer pool.Close()
+	upstream.StartHealthCheck(HTTPHealthCheck{Path: "/health", Interval: 10 * time.Millisecond, HealthyThreshold: 1, UnhealthyThreshold: 2})
+	defer upstream.Close()
+
+	time.Sleep(50 * time.Millisecond)
+	if len(upstream.available()) != 2 {
+		t.Fail()
+	}
+	atomic.StoreInt32(&healthy, 0)
+	time.Sleep(50 * time.Millisecond)
+	if upstream.Endpoints()[0].Healthy() {
+		t.Fail()
+	}
+	for i := 0; i < 4; i++ {
+		if body, _ := requestBody(pool, "http://service/"); body != "other" {
+			t.Errorf("body:%s", body)
+		}
+	}
+	if status := pool.Status(); !strings.Contains(status, serverAddr(server)+":unhealthy") {
+		t.Errorf("status:%s", status)
+	}
+	atomic.StoreInt32(&healthy, 1)
+	time.Sleep(50 * time.Millisecond)
+	if !upstream.Endpoints()[0].Healthy() {
+		t.Fail()
+	}
+}
+
+// waitHealthy 等待节点变为指定状态，最多1s，返回节点最后的状态
+func waitHealthy(ep *HTTPEndpoint, healthy bool) bool {
+	for i := 0; i < 100 && ep.Healthy() != healthy; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	return ep.Healthy()
+}
+
+func Test_HTTPHealthCheckPoolTransport(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	tlsConfig := server.Client().Transport.(*http.Transport).TLSClientConfig
+	pool := NewHTTPConnectionPoolWithOptions(WithTimeout(time.Second), WithPoolNum(1), WithTLSConfig(tlsConfig))
+	defer pool.Close()
+	check := HTTPHealthCheck{Interval: 10 * time.Millisecond, HealthyThreshold: 1, UnhealthyThreshold: 1}
+
+	// 默认Transport不信任测试证书
+	upstream, _ := NewHTTPUpstream(NewStaticResolver(serverAddr(server)), nil)
+	defer upstream.Close()
+	upstream.Endpoints()[0].Scheme = "https"
+	upstream.StartHealthCheck(check)
+	if waitHealthy(upstream.Endpoints()[0], false) {
+		t.Error("probe without pool transport should fail")
+	}
+	firstStop := upstream.healthStop
+
+	check.Transport = pool.Transport()
+	upstream.StartHealthCheck(check)
+	if !waitHealthy(upstream.Endpoints()[0], true) {
+		t.Error("probe with pool transport should succeed")
+	}
+	select {
+	case <-firstStop:
+	default:
+		t.Error("restarting health check should stop the previous checker")
+	}
+}
+
+func Test_HTTPHealthExpectedStatus(t *testing.T) {
+	server := newNamedServer("created", http.StatusCreated)
+	defer server.Close()
+	ep := &HTTPEndpoint{Addr: serverAddr(server)}
+	client := &http.Client{Timeout: time.Second}
+	if err := ep.probe(client, HTTPHealthCheck{Path: "/"}); err != nil {
+		t.Error(err.Error())
+	}
+	if err := ep.probe(client, HTTPHealthCheck{Path: "/", ExpectedStatus: 200}); err == nil {
+		t.Fail()
+	}
+}
@@ -0,0 +1,231 @@
+package goutils
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	defaultHedgePercentile = 0.95
+	defaultHedgeMaxRatio   = 0.05
+	defaultHedgeMethods    = []string{"GET", "HEAD", "OPTIONS"}
+	hedgeMinSamples        = 20
+	latencyWindowSize      = 256
+)
+
+// HTTPHedge 对冲请求配置：请求发出Delay后仍没有响应时再发一个相同的请求，
+// 取先成功返回的响应并取消另一个。只对幂等且没有body的请求生效
+type HTTPHedge struct {
+	Delay      time.Duration                             //对冲延迟，0表示使用观测到的耗时分位数
+	Percentile float64                                   //Delay为0时使用的耗时分位数，默认0.95
+	MaxRatio   float64                                   //对冲请求占请求总数的比例上限，默认0.05
+	Methods    []string                                  //允许对冲的请求方法，默认GET、HEAD、OPTIONS
+	Rewrite    func(request *http.Request) *http.Request //改写对冲请求，比如发往另一个endpoint
+}
+
+type httpHedger struct {
+	config     HTTPHedge
+	requestNum int64 //符合对冲条件的请求数
+	hedgeNum   int64 //发出的对冲请求数
+}
+
+type httpAttempt struct {
+	httpData *HTTPData
+	cancel   context.CancelFunc
+}
+
+func (a *httpAttempt) success() bool {
+	return a.httpData.Err == nil && a.httpData.Response.StatusCode < http.StatusInternalServerError
+}
+
+func (a *httpAttempt) discard() {
+	a.cancel()
+	CloseResponse(a.httpData.Response)
+}
+
+// latencyWindow 最近请求耗时的滑动窗口
+type latencyWindow struct {
+	lock    *sync.Mutex
+	samples []time.Duration
+	next    int
+}
+
+func newLatencyWindow(size int) *latencyWindow {
+	return &latencyWindow{lock: new(sync.Mutex), samples: make([]time.Duration, 0, size)}
+}
+
+func (w *latencyWindow) add(latency time.Duration) {
+	w.lock.Lock()
+	if len(w.samples) < cap(w.samples) {
+		w.samples = append(w.samples, latency)
+	} else {
+		w.samples[w.next] = latency
+		w.next = (w.next + 1) % len(w.samples)
+	}
+	w.lock.Unlock()
+}
+
+// percentile 样本数不足minSamples时返回false
+func (w *latencyWindow) percentile(p float64, minSamples int) (time.Duration, bool) {
+	w.lock.Lock()
+	if len(w.samples) < minSamples || len(w.samples) == 0 {
+		w.lock.Unlock()
+		return 0, false
+	}
+	samples := make([]time.Duration, len(w.samples))
+	copy(samples, w.samples)
+	w.lock.Unlock()
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	index := int(float64(len(samples))*p+0.5) - 1
+	if index < 0 {
+		index = 0
+	} else if index >= len(samples) {
+		index = len(samples) - 1
+	}
+	return samples[index], true
+}
+
+// SetHedge 设置对冲请求配置，nil表示关闭
+func (cp *HTTPConnectionPool) SetHedge(hedge *HTTPHedge) {
+	var hedger *httpHedger
+	if hedge != nil {
+		config := *hedge
+		if config.Percentile <= 0 || config.Percentile >= 1 {
+			config.Percentile = defaultHedgePercentile
+		}
+		if config.MaxRatio <= 0 {
+			config.MaxRatio = defaultHedgeMaxRatio
+		}
+		if len(config.Methods) == 0 {
+			config.Methods = defaultHedgeMethods
+		}
+		hedger = &httpHedger{config: config}
+	}
+	cp.lock.Lock()
+	cp.hedger = hedger
+	cp.lock.Unlock()
+}
+
+func (cp *HTTPConnectionPool) getHedger() *httpHedger {
+	cp.lock.Lock()
+	defer cp.lock.Unlock()
+	return cp.hedger
+}
+
+// eligible 流式请求和有进度回调的请求不对冲，多个请求的进度会交错回调
+func (h *httpHedger) eligible(httpData *HTTPData) bool {
+	request := httpData.Request
+	if httpData.Stream || httpData.Progress != nil || httpData.UploadProgress != nil ||
+		request == nil || (request.Body != nil && request.Body != http.NoBody) {
+		return false
+	}
+	for _, method := range h.config.Methods {
+		if method == request.Method {
+			return true
+		}
+	}
+	return false
+}
+
+// allow 对冲比例未超过上限时返回true并计数
+func (h *httpHedger) allow() bool {
+	requestNum := atomic.LoadInt64(&h.requestNum)
+	for {
+		hedgeNum := atomic.LoadInt64(&h.hedgeNum)
+		if float64(hedgeNum+1) > h.config.MaxRatio*float64(requestNum) {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(&h.hedgeNum, hedgeNum, hedgeNum+1) {
+			return true
+		}
+	}
+}
+
+func (cp *HTTPConnectionPool) hedgeDelay(h *httpHedger) time.Duration {
+	if h.config.Delay > 0 {
+		return h.config.Delay
+	}
+	delay, _ := cp.latency.percentile(h.config.Percentile, hedgeMinSamples)
+	return delay
+}
+
+// attempt 提交一次请求，完成或者超过截止时间后发送到done
+func (cp *HTTPConnectionPool) attempt(httpData *HTTPData, request *http.Request, done chan *httpAttempt) *httpAttempt {
+	ctx, cancel := context.WithCancel(httpData.requestContext())
+	child := NewHTTPData(request.WithContext(ctx))
+	child.Priority = httpData.Priority
+	child.cache = httpData.cache
+	child.Validate, child.Decode = httpData.Validate, httpData.Decode
+	child.enqueued = time.Now()
+	child.deadline = httpData.deadline
+	a := &httpAttempt{httpData: child, cancel: cancel}
+	cp.submit(child)
+	go func() {
+		cp.wait(child)
+		done <- a
+	}()
+	return a
+}
+
+// doHedged 执行对冲请求，结果保存在httpData中
+func (cp *HTTPConnectionPool) doHedged(httpData *HTTPData, h *httpHedger) {
+	atomic.AddInt64(&h.requestNum, 1)
+	done := make(chan *httpAttempt, 2)
+	attempts := []*httpAttempt{cp.attempt(httpData, httpData.Request, done)}
+	var hedgeTimer <-chan time.Time
+	if delay := cp.hedgeDelay(h); delay > 0 {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		hedgeTimer = timer.C
+	}
+	var result *httpAttempt
+	finished := 0
+	for result == nil {
+		select {
+		case a := <-done:
+			finished++
+			if a.success() || finished == len(attempts) {
+				result = a
+			} else {
+				a.discard()
+			}
+		case <-hedgeTimer:
+			hedgeTimer = nil
+			if !h.allow() {
+				continue
+			}
+			atomic.AddInt64(&cp.stats.hedgeNum, 1)
+			request := httpData.Request
+			if h.config.Rewrite != nil {
+				request = h.config.Rewrite(request)
+			}
+			attempts = append(attempts, cp.attempt(httpData, request, done))
+		}
+	}
+	for _, a := range attempts {
+		if a != result {
+			a.cancel()
+		}
+	}
+	// 被取消的请求仍可能返回响应，需要关闭body
+	go func(remaining int) {
+		for i := 0; i < remaining; i++ {
+			(<-done).discard()
+		}
+	}(len(attempts) - finished)
+
+	child := result.httpData
+	httpData.retries = len(attempts) - 1
+	httpData.Response, httpData.Err = child.Response, child.Err
+	httpData.QueueWait, httpData.ExecTime = child.QueueWait, child.ExecTime
+	httpData.Result, httpData.DecodeErr, httpData.decoded = child.Result, child.DecodeErr, child.decoded
+	if child.Response != nil {
+		child.Response.Body = &cancelBody{ReadCloser: child.Response.Body, cancel: result.cancel}
+	} else {
+		result.cancel()
+	}
+}
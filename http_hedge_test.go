@@ -0,0 +1,112 @@
+package goutils
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newFirstSlowServer(delay time.Duration) *httptest.Server {
+	var num int64
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt64(&num, 1) == 1 {
+			select {
+			case <-time.After(delay):
+			case <-r.Context().Done():
+				return
+			}
+			w.Write([]byte("slow"))
+			return
+		}
+		w.Write([]byte("fast"))
+	}))
+}
+
+func Test_HTTPHedge(t *testing.T) {
+	server := newFirstSlowServer(time.Second)
+	defer server.Close()
+	pool := NewHTTPConnectionPoolWithOptions(WithTimeout(2*time.Second), WithPoolNum(2),
+		WithHedge(HTTPHedge{Delay: 50 * time.Millisecond, MaxRatio: 1}))
+	defer pool.Close()
+	time.Sleep(20 * time.Millisecond)
+	request, _ := http.NewRequest("GET", server.URL, nil)
+	start := time.Now()
+	response, err := pool.Request(request)
+	if err != nil {
+		t.Fatalf("request err:%s", err.Error())
+	}
+	body, _ := io.ReadAll(response.Body)
+	response.Body.Close()
+	if string(body) != "fast" || time.Since(start) > 500*time.Millisecond {
+		t.Errorf("body:%s cost:%v", body, time.Since(start))
+	}
+	if pool.loadStats().hedgeNum != 1 {
+		t.Fail()
+	}
+}
+
+func Test_HTTPHedgeRatio(t *testing.T) {
+	server := newFirstSlowServer(200 * time.Millisecond)
+	defer server.Close()
+	pool := NewHTTPConnectionPoolWithOptions(WithTimeout(time.Second), WithPoolNum(2),
+		WithHedge(HTTPHedge{Delay: 10 * time.Millisecond, MaxRatio: 0.5}))
+	defer pool.Close()
+	time.Sleep(20 * time.Millisecond)
+	request, _ := http.NewRequest("GET", server.URL, nil)
+	response, err := pool.Request(request)
+	if err != nil {
+		t.Fatalf("request err:%s", err.Error())
+	}
+	response.Body.Close()
+	if pool.loadStats().hedgeNum != 0 {
+		t.Fail()
+	}
+}
+
+func Test_HTTPHedgeNotEligible(t *testing.T) {
+	hedger := &httpHedger{config: HTTPHedge{Methods: defaultHedgeMethods}}
+	request, _ := http.NewRequest("POST", "http://127.0.0.1/", nil)
+	if hedger.eligible(NewHTTPData(request)) {
+		t.Fail()
+	}
+	request, _ = http.NewRequest("GET", "http://127.0.0.1/", nil)
+	if !hedger.eligible(NewHTTPData(request)) {
+		t.Fail()
+	}
+}
+
+func Test_HTTPHedgeProgress(t *testing.T) {
+	server := newFirstSlowServer(200 * time.Millisecond)
+	defer server.Close()
+	pool := NewHTTPConnectionPoolWithOptions(WithTimeout(time.Second), WithPoolNum(2),
+		WithHedge(HTTPHedge{Delay: 10 * time.Millisecond, MaxRatio: 1}))
+	defer pool.Close()
+	request, _ := http.NewRequest("GET", server.URL, nil)
+	httpData := NewHTTPData(request)
+	var done int64
+	httpData.Progress = func(d, total int64) { done = d }
+	if err := pool.Do(httpData); err != nil {
+		t.Fatal(err.Error())
+	}
+	body, _ := io.ReadAll(httpData.Response.Body)
+	httpData.Response.Body.Close()
+	if string(body) != "slow" || done != 4 || pool.loadStats().hedgeNum != 0 {
+		t.Errorf("body:%s done:%d hedgeNum:%d", body, done, pool.loadStats().hedgeNum)
+	}
+}
+
+func Test_LatencyWindowPercentile(t *testing.T) {
+	window := newLatencyWindow(100)
+	if _, ok := window.percentile(0.95, 1); ok {
+		t.Fail()
+	}
+	for i := 1; i <= 200; i++ {
+		window.add(time.Duration(i) * time.Millisecond)
+	}
+	if p, ok := window.percentile(0.95, 1); !ok || p != 195*time.Millisecond {
+		t.Errorf("percentile:%v", p)
+	}
+}
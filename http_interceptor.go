@@ -0,0 +1,149 @@
+package goutils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var errorSignBodyUnreadable = errors.New("ERROR_HTTP_SIGN_BODY_UNREADABLE")
+
+// RoundTripFunc 发出一个http请求
+type RoundTripFunc func(request *http.Request) (*http.Response, error)
+
+// Interceptor 请求拦截器，包装next实现请求前后的处理
+type Interceptor func(next RoundTripFunc) RoundTripFunc
+
+// Use 注册拦截器，先注册的在外层，对之后执行的每个请求生效
+// 拦截器在选出后端节点之后执行，看到的是最终发出的请求
+func (cp *HTTPConnectionPool) Use(interceptors ...Interceptor) {
+	cp.lock.Lock()
+	defer cp.lock.Unlock()
+	cp.interceptors = append(cp.interceptors, interceptors...)
+	send := cp.send
+	for i := len(cp.interceptors) - 1; i >= 0; i-- {
+		send = cp.interceptors[i](send)
+	}
+	cp.chain = send
+}
+
+func (cp *HTTPConnectionPool) getChain() RoundTripFunc {
+	cp.lock.Lock()
+	defer cp.lock.Unlock()
+	if cp.chain == nil {
+		return cp.send
+	}
+	return cp.chain
+}
+
+// send 拦截器链的最内层，开启故障注入时在这里注入，结果计入后端节点的异常剔除
+func (cp *HTTPConnectionPool) send(request *http.Request) (*http.Response, error) {
+	var response *http.Response
+	var err error
+	if f := cp.getFaultInjector(); f != nil {
+		response, err = f.inject(request, cp.getClient().Do)
+	} else {
+		response, err = cp.getClient().Do(request)
+	}
+	observeUpstream(request, response, err)
+	return response, err
+}
+
+// LoggingInterceptor 记录请求日志，请求的context中有ServerContext时通过ServerContext输出
+func LoggingInterceptor() Interceptor {
+	return func(next RoundTripFunc) RoundTripFunc {
+		return func(request *http.Request) (*http.Response, error) {
+			start := time.Now()
+			response, err := next(request)
+			cost := time.Since(start)
+			sc := ServerContextFrom(request.Context())
+			if err != nil {
+				if sc != nil {
+					sc.Warning("http request method=%s url=%s cost=%v err=%s", request.Method, request.URL, cost, err.Error())
+				} else {
+					Log.Warning("http request method=%s url=%s cost=%v err=%s", request.Method, request.URL, cost, err.Error())
+				}
+				return response, err
+			}
+			if sc != nil {
+				sc.Info("http request method=%s url=%s status=%d cost=%v", request.Method, request.URL, response.StatusCode, cost)
+			} else {
+				Log.Info("http request method=%s url=%s status=%d cost=%v", request.Method, request.URL, response.StatusCode, cost)
+			}
+			return response, err
+		}
+	}
+}
+
+// HeaderInterceptor 给每个请求设置固定的请求头
+func HeaderInterceptor(header http.Header) Interceptor {
+	return func(next RoundTripFunc) RoundTripFunc {
+		return func(request *http.Request) (*http.Response, error) {
+			request = request.Clone(request.Context())
+			for key, values := range header {
+				// 复制一份，避免后续修改请求头时改到共享的配置
+				request.Header[key] = append([]string(nil), values...)
+			}
+			return next(request)
+		}
+	}
+}
+
+// UserAgentInterceptor 设置User-Agent
+func UserAgentInterceptor(userAgent string) Interceptor {
+	return HeaderInterceptor(http.Header{"User-Agent": {userAgent}})
+}
+
+// BearerTokenInterceptor 设置Authorization: Bearer <token>，token每次请求时获取，方便刷新
+func BearerTokenInterceptor(token func() (string, error)) Interceptor {
+	return func(next RoundTripFunc) RoundTripFunc {
+		return func(request *http.Request) (*http.Response, error) {
+			value, err := token()
+			if err != nil {
+				return nil, err
+			}
+			request = request.Clone(request.Context())
+			request.Header.Set("Authorization", "Bearer "+value)
+			return next(request)
+		}
+	}
+}
+
+// HMACSignInterceptor HMAC-SHA256签名，签名内容为
+// "METHOD\nRequestURI\nTimestamp\nhex(sha256(body))"，
+// 时间戳(unix秒)写入X-Timestamp，签名的hex写入header指定的请求头
+// 有body的请求需要能通过GetBody重新读取，http.NewRequest对常用的body类型会自动设置，
+// 没有GetBody时返回错误，避免对空body签名
+func HMACSignInterceptor(secret []byte, header string) Interceptor {
+	return func(next RoundTripFunc) RoundTripFunc {
+		return func(request *http.Request) (*http.Response, error) {
+			bodyHash := sha256.New()
+			if request.GetBody == nil && request.Body != nil && request.Body != http.NoBody {
+				return nil, errorSignBodyUnreadable
+			}
+			if request.GetBody != nil {
+				body, err := request.GetBody()
+				if err != nil {
+					return nil, err
+				}
+				_, err = io.Copy(bodyHash, body)
+				body.Close()
+				if err != nil {
+					return nil, err
+				}
+			}
+			timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+			mac := hmac.New(sha256.New, secret)
+			io.WriteString(mac, request.Method+"\n"+request.URL.RequestURI()+"\n"+timestamp+"\n"+hex.EncodeToString(bodyHash.Sum(nil)))
+			request = request.Clone(request.Context())
+			request.Header.Set("X-Timestamp", timestamp)
+			request.Header.Set(header, hex.EncodeToString(mac.Sum(nil)))
+			return next(request)
+		}
+	}
+}
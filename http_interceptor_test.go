@@ -0,0 +1,164 @@
+package goutils
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_HTTPInterceptorOrder(t *testing.T) {
+	server := newNamedServer("a", http.StatusOK)
+	defer server.Close()
+	pool := NewHTTPConnectionPool(time.Second, 2)
+	defer pool.Close()
+	lock := new(sync.Mutex)
+	var order []string
+	trace := func(name string) Interceptor {
+		return func(next RoundTripFunc) RoundTripFunc {
+			return func(request *http.Request) (*http.Response, error) {
+				lock.Lock()
+				order = append(order, name)
+				lock.Unlock()
+				return next(request)
+			}
+		}
+	}
+	pool.Use(trace("first"), trace("second"))
+	pool.Use(trace("third"))
+	if _, err := requestBody(pool, server.URL); err != nil {
+		t.Fatal(err.Error())
+	}
+	if strings.Join(order, ",") != "first,second,third" {
+		t.Errorf("order:%v", order)
+	}
+}
+
+func Test_HTTPInterceptorBatch(t *testing.T) {
+	var count int64
+	lock := new(sync.Mutex)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("User-Agent") != "goutils-test" || r.Header.Get("X-Env") != "test" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		lock.Lock()
+		count++
+		lock.Unlock()
+	}))
+	defer server.Close()
+	pool := NewHTTPConnectionPool(time.Second, 4)
+	defer pool.Close()
+	pool.Use(UserAgentInterceptor("goutils-test"), HeaderInterceptor(http.Header{"X-Env": {"test"}}), LoggingInterceptor())
+	var batch []*HTTPData
+	for i := 0; i < 3; i++ {
+		request, _ := http.NewRequest("GET", server.URL, nil)
+		batch = append(batch, NewHTTPData(request))
+	}
+	pool.BatchRequest(batch)
+	for _, data := range batch {
+		if data.Err != nil || data.Response.StatusCode != http.StatusOK {
+			t.Errorf("data err:%v response:%v", data.Err, data.Response)
+			continue
+		}
+		CloseResponse(data.Response)
+		if data.Request.Header.Get("X-Env") != "" {
+			t.Error("caller request modified")
+		}
+	}
+	if count != 3 {
+		t.Errorf("count:%d", count)
+	}
+}
+
+func Test_HTTPBearerTokenInterceptor(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get("Authorization")))
+	}))
+	defer server.Close()
+	pool := NewHTTPConnectionPool(time.Second, 2)
+	defer pool.Close()
+	token := "t1"
+	pool.Use(BearerTokenInterceptor(func() (string, error) {
+		if token == "" {
+			return "", errors.New("no token")
+		}
+		return token, nil
+	}))
+	body, err := requestBody(pool, server.URL)
+	if err != nil || body != "Bearer t1" {
+		t.Errorf("body:%s err:%v", body, err)
+	}
+	token = ""
+	if _, err := requestBody(pool, server.URL); err == nil {
+		t.Error("expect token error")
+	}
+}
+
+func Test_HTTPHMACSignInterceptor(t *testing.T) {
+	secret := []byte("secret")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodyHash := sha256.Sum256(body)
+		mac := hmac.New(sha256.New, secret)
+		io.WriteString(mac, r.Method+"\n"+r.URL.RequestURI()+"\n"+r.Header.Get("X-Timestamp")+"\n"+hex.EncodeToString(bodyHash[:]))
+		if hex.EncodeToString(mac.Sum(nil)) != r.Header.Get("X-Signature") {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer server.Close()
+	pool := NewHTTPConnectionPool(time.Second, 2)
+	defer pool.Close()
+	pool.Use(HMACSignInterceptor(secret, "X-Signature"))
+	request, _ := http.NewRequest("POST", server.URL+"/sign?a=1", strings.NewReader("payload"))
+	response, err := pool.Request(request)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	CloseResponse(response)
+	if response.StatusCode != http.StatusOK {
+		t.Errorf("status:%d", response.StatusCode)
+	}
+
+	// 不能重新读取的body无法签名
+	request, _ = http.NewRequest("POST", server.URL+"/sign", io.NopCloser(strings.NewReader("payload")))
+	if _, err = pool.Request(request); !errors.Is(err, errorSignBodyUnreadable) {
+		t.Errorf("request err:%v", err)
+	}
+}
+
+func Test_HTTPHeaderInterceptorCopy(t *testing.T) {
+	header := http.Header{"X-Env": {"test"}}
+	intercept := HeaderInterceptor(header)(func(request *http.Request) (*http.Response, error) {
+		request.Header["X-Env"][0] = "changed"
+		return nil, nil
+	})
+	request, _ := http.NewRequest("GET", "http://127.0.0.1/", nil)
+	intercept(request)
+	if header.Get("X-Env") != "test" {
+		t.Errorf("shared header changed: %v", header)
+	}
+}
+
+func Test_HTTPLoggingInterceptorServerContext(t *testing.T) {
+	server := newNamedServer("a", http.StatusOK)
+	defer server.Close()
+	pool := NewHTTPConnectionPool(time.Second, 2)
+	defer pool.Close()
+	pool.Use(LoggingInterceptor())
+	sc := NewContext("test")
+	request, _ := http.NewRequestWithContext(WithServerContext(context.Background(), sc), "GET", server.URL, nil)
+	response, err := pool.Request(request)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	CloseResponse(response)
+}
@@ -0,0 +1,205 @@
+package goutils
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var (
+	errorResponseTooLarge = errors.New("ERROR_HTTP_RESPONSE_TOO_LARGE")
+
+	defaultMaxResponseSize int64 = 10 << 20
+	statusErrorBodySize          = 512
+)
+
+// HTTPStatusError 非2xx响应的错误，Body为响应内容的开头部分
+type HTTPStatusError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Body       string
+}
+
+func (e *HTTPStatusError) Error() string {
+	return fmt.Sprintf("%s %s: unexpected status %d, body: %s", e.Method, e.URL, e.StatusCode, e.Body)
+}
+
+// newStatusError body超过statusErrorBodySize时截断，request为nil时不记录方法和URL
+func newStatusError(request *http.Request, statusCode int, body []byte) *HTTPStatusError {
+	if len(body) > statusErrorBodySize {
+		body = body[:statusErrorBodySize]
+	}
+	e := &HTTPStatusError{StatusCode: statusCode, Body: string(body)}
+	if request != nil {
+		e.Method, e.URL = request.Method, request.URL.String()
+	}
+	return e
+}
+
+// HTTPRequestBuilder http请求构造器，出错时在Build返回
+type HTTPRequestBuilder struct {
+	ctx         context.Context
+	method      string
+	url         string
+	query       url.Values
+	header      http.Header
+	body        io.Reader
+	contentType string
+	err         error
+}
+
+// NewRequestBuilder 构造函数
+func NewRequestBuilder(method, rawurl string) *HTTPRequestBuilder {
+	return &HTTPRequestBuilder{
+		ctx:    context.Background(),
+		method: method,
+		url:    rawurl,
+		query:  make(url.Values),
+		header: make(http.Header),
+	}
+}
+
+// Context 设置请求的context
+func (b *HTTPRequestBuilder) Context(ctx context.Context) *HTTPRequestBuilder {
+	b.ctx = ctx
+	return b
+}
+
+// Query 添加url参数
+func (b *HTTPRequestBuilder) Query(key, value string) *HTTPRequestBuilder {
+	b.query.Add(key, value)
+	return b
+}
+
+// Header 设置请求头
+func (b *HTTPRequestBuilder) Header(key, value string) *HTTPRequestBuilder {
+	b.header.Set(key, value)
+	return b
+}
+
+// Form 设置表单body
+func (b *HTTPRequestBuilder) Form(form url.Values) *HTTPRequestBuilder {
+	return b.Body(strings.NewReader(form.Encode()), "application/x-www-form-urlencoded")
+}
+
+// JSON 设置json body
+func (b *HTTPRequestBuilder) JSON(v interface{}) *HTTPRequestBuilder {
+	data, err := json.Marshal(v)
+	if err != nil {
+		b.err = err
+		return b
+	}
+	return b.Body(bytes.NewReader(data), "application/json")
+}
+
+// Body 设置body和Content-Type
+func (b *HTTPRequestBuilder) Body(body io.Reader, contentType string) *HTTPRequestBuilder {
+	b.body = body
+	b.contentType = contentType
+	return b
+}
+
+// Build 生成http.Request
+func (b *HTTPRequestBuilder) Build() (*http.Request, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	u, err := url.Parse(b.url)
+	if err != nil {
+		return nil, err
+	}
+	if len(b.query) > 0 {
+		query := u.Query()
+		for key, values := range b.query {
+			for _, value := range values {
+				query.Add(key, value)
+			}
+		}
+		u.RawQuery = query.Encode()
+	}
+	request, err := http.NewRequestWithContext(b.ctx, b.method, u.String(), b.body)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range b.header {
+		request.Header[key] = values
+	}
+	if len(b.contentType) > 0 && len(request.Header.Get("Content-Type")) == 0 {
+		request.Header.Set("Content-Type", b.contentType)
+	}
+	return request, nil
+}
+
+// SetMaxResponseSize 设置DoJSON等方法读取响应的最大字节数，默认10MB
+func (cp *HTTPConnectionPool) SetMaxResponseSize(size int64) {
+	cp.lock.Lock()
+	cp.maxResponseSize = size
+	cp.lock.Unlock()
+}
+
+func (cp *HTTPConnectionPool) getMaxResponseSize() int64 {
+	cp.lock.Lock()
+	defer cp.lock.Unlock()
+	if cp.maxResponseSize <= 0 {
+		return defaultMaxResponseSize
+	}
+	return cp.maxResponseSize
+}
+
+// DoBytes 执行请求并读取完整的响应body，非2xx响应返回*HTTPStatusError，body总会被关闭
+func (cp *HTTPConnectionPool) DoBytes(request *http.Request) ([]byte, error) {
+	response, err := cp.Request(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	maxSize := cp.getMaxResponseSize()
+	body, err := io.ReadAll(io.LimitReader(response.Body, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode/100 != 2 {
+		return nil, newStatusError(request, response.StatusCode, body)
+	}
+	if int64(len(body)) > maxSize {
+		return nil, errorResponseTooLarge
+	}
+	return body, nil
+}
+
+// DoJSON 执行请求并把json响应解析到out，out为nil时忽略响应内容
+func (cp *HTTPConnectionPool) DoJSON(request *http.Request, out interface{}) error {
+	body, err := cp.DoBytes(request)
+	if err != nil {
+		return err
+	}
+	if out == nil || len(body) == 0 {
+		return nil
+	}
+	return json.Unmarshal(body, out)
+}
+
+// GetJSON GET请求并解析json响应
+func (cp *HTTPConnectionPool) GetJSON(ctx context.Context, rawurl string, out interface{}) error {
+	request, err := NewRequestBuilder("GET", rawurl).Context(ctx).Header("Accept", "application/json").Build()
+	if err != nil {
+		return err
+	}
+	return cp.DoJSON(request, out)
+}
+
+// PostJSON 以json格式POST in并解析json响应到out
+func (cp *HTTPConnectionPool) PostJSON(ctx context.Context, rawurl string, in, out interface{}) error {
+	request, err := NewRequestBuilder("POST", rawurl).Context(ctx).Header("Accept", "application/json").JSON(in).Build()
+	if err != nil {
+		return err
+	}
+	return cp.DoJSON(request, out)
+}
@@ -0,0 +1,138 @@
+package goutils
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+type jsonEcho struct {
+	Method string            `json:"method"`
+	Query  string            `json:"query"`
+	Header string            `json:"header"`
+	Body   string            `json:"body"`
+	Data   map[string]string `json:"data,omitempty"`
+}
+
+func newJSONServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/error":
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"bad request"}`))
+			return
+		case "/large":
+			w.Write([]byte(strings.Repeat("x", 2048)))
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		json.NewEncoder(w).Encode(jsonEcho{
+			Method: r.Method,
+			Query:  r.URL.RawQuery,
+			Header: r.Header.Get("X-Test"),
+			Body:   string(body),
+		})
+	}))
+}
+
+func Test_HTTPGetJSON(t *testing.T) {
+	server := newJSONServer()
+	defer server.Close()
+	pool := NewHTTPConnectionPool(time.Second, 2)
+	defer pool.Close()
+	out := new(jsonEcho)
+	if err := pool.GetJSON(context.Background(), server.URL+"/echo?a=1", out); err != nil {
+		t.Fatal(err.Error())
+	}
+	if out.Method != "GET" || out.Query != "a=1" {
+		t.Errorf("out:%+v", out)
+	}
+}
+
+func Test_HTTPPostJSON(t *testing.T) {
+	server := newJSONServer()
+	defer server.Close()
+	pool := NewHTTPConnectionPool(time.Second, 2)
+	defer pool.Close()
+	out := new(jsonEcho)
+	in := map[string]string{"k": "v"}
+	if err := pool.PostJSON(context.Background(), server.URL+"/echo", in, out); err != nil {
+		t.Fatal(err.Error())
+	}
+	if out.Method != "POST" || out.Body != `{"k":"v"}` {
+		t.Errorf("out:%+v", out)
+	}
+}
+
+func Test_HTTPJSONStatusError(t *testing.T) {
+	server := newJSONServer()
+	defer server.Close()
+	pool := NewHTTPConnectionPool(time.Second, 2)
+	defer pool.Close()
+	err := pool.GetJSON(context.Background(), server.URL+"/error", nil)
+	statusErr := new(HTTPStatusError)
+	if !errors.As(err, &statusErr) {
+		t.Fatalf("err:%v", err)
+	}
+	if statusErr.StatusCode != http.StatusBadRequest || !strings.Contains(statusErr.Body, "bad request") {
+		t.Errorf("err:%s", statusErr.Error())
+	}
+}
+
+func Test_HTTPJSONTooLarge(t *testing.T) {
+	server := newJSONServer()
+	defer server.Close()
+	pool := NewHTTPConnectionPoolWithOptions(WithTimeout(time.Second), WithPoolNum(1), WithMaxResponseSize(1024))
+	defer pool.Close()
+	request, _ := http.NewRequest("GET", server.URL+"/large", nil)
+	if _, err := pool.DoBytes(request); err != errorResponseTooLarge {
+		t.Errorf("err:%v", err)
+	}
+}
+
+func Test_HTTPJSONContextCanceled(t *testing.T) {
+	server := newSlowServer(time.Second)
+	defer server.Close()
+	pool := NewHTTPConnectionPool(2*time.Second, 1)
+	defer pool.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := pool.GetJSON(ctx, server.URL, nil); err == nil {
+		t.Fail()
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Errorf("cost:%v", time.Since(start))
+	}
+}
+
+func Test_HTTPRequestBuilder(t *testing.T) {
+	request, err := NewRequestBuilder("POST", "http://127.0.0.1/path?a=1").
+		Query("b", "2").
+		Header("X-Test", "yes").
+		Form(url.Values{"k": {"v"}}).
+		Build()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if request.URL.RawQuery != "a=1&b=2" || request.Header.Get("X-Test") != "yes" {
+		t.Errorf("request:%v", request)
+	}
+	if request.Header.Get("Content-Type") != "application/x-www-form-urlencoded" {
+		t.Fail()
+	}
+	body, _ := io.ReadAll(request.Body)
+	if string(body) != "k=v" {
+		t.Fail()
+	}
+	if _, err := NewRequestBuilder("POST", "http://127.0.0.1/").JSON(make(chan int)).Build(); err == nil {
+		t.Fail()
+	}
+}
@@ -0,0 +1,113 @@
+package goutils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MockResponse 模拟后端的一次响应
+type MockResponse struct {
+	Status int           //状态码，默认200
+	Header http.Header   //响应头
+	Body   string        //响应内容
+	Delay  time.Duration //返回响应前的延迟，请求被取消时提前结束
+	Drop   bool          //不返回响应，直接断开连接
+}
+
+// MockUpstream 基于httptest的模拟后端，按path设置依次返回的响应，用于测试连接池的超时、连接池满等行为
+type MockUpstream struct {
+	URL             string
+	server          *httptest.Server
+	lock            *sync.Mutex
+	scripts         map[string][]MockResponse
+	defaultResponse MockResponse
+	requests        map[string]int
+}
+
+// NewMockUpstream 启动模拟后端，没有设置脚本的path返回200
+func NewMockUpstream() *MockUpstream {
+	m := &MockUpstream{
+		lock:     new(sync.Mutex),
+		scripts:  make(map[string][]MockResponse),
+		requests: make(map[string]int),
+	}
+	m.server = httptest.NewServer(http.HandlerFunc(m.serveHTTP))
+	m.URL = m.server.URL
+	return m
+}
+
+// Addr 模拟后端的host:port，可以用作HTTPEndpoint的地址
+func (m *MockUpstream) Addr() string {
+	return strings.TrimPrefix(m.URL, "http://")
+}
+
+// Script 设置path依次返回的响应，用完后重复最后一个
+func (m *MockUpstream) Script(path string, responses ...MockResponse) {
+	m.lock.Lock()
+	m.scripts[path] = responses
+	m.lock.Unlock()
+}
+
+// SetDefault 设置没有脚本的path返回的响应
+func (m *MockUpstream) SetDefault(response MockResponse) {
+	m.lock.Lock()
+	m.defaultResponse = response
+	m.lock.Unlock()
+}
+
+// Requests path收到的请求数
+func (m *MockUpstream) Requests(path string) int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.requests[path]
+}
+
+// Close 关闭模拟后端
+func (m *MockUpstream) Close() {
+	m.server.Close()
+}
+
+func (m *MockUpstream) next(path string) MockResponse {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.requests[path]++
+	script, ok := m.scripts[path]
+	if !ok || len(script) == 0 {
+		return m.defaultResponse
+	}
+	if len(script) > 1 {
+		m.scripts[path] = script[1:]
+	}
+	return script[0]
+}
+
+func (m *MockUpstream) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	response := m.next(r.URL.Path)
+	if response.Delay > 0 {
+		select {
+		case <-time.After(response.Delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	if response.Drop {
+		if hijacker, ok := w.(http.Hijacker); ok {
+			if conn, _, err := hijacker.Hijack(); err == nil {
+				conn.Close()
+				return
+			}
+		}
+		panic(http.ErrAbortHandler)
+	}
+	for key, values := range response.Header {
+		w.Header()[key] = values
+	}
+	if response.Status == 0 {
+		response.Status = http.StatusOK
+	}
+	w.WriteHeader(response.Status)
+	w.Write([]byte(response.Body))
+}
@@ -0,0 +1,51 @@
+package goutils
+
+import (
+	"io"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func Test_MockUpstreamScript(t *testing.T) {
+	upstream := NewMockUpstream()
+	defer upstream.Close()
+	upstream.Script("/flaky",
+		MockResponse{Status: http.StatusServiceUnavailable},
+		MockResponse{Body: "ok", Header: http.Header{"X-Mock": {"1"}}})
+	pool := NewHTTPConnectionPool(time.Second, 2)
+	defer pool.Close()
+	for i, expect := range []int{http.StatusServiceUnavailable, http.StatusOK, http.StatusOK} {
+		response, err := pool.Request(newGetRequest(upstream.URL + "/flaky"))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		body, _ := io.ReadAll(response.Body)
+		response.Body.Close()
+		if response.StatusCode != expect {
+			t.Errorf("request:%d status:%d", i, response.StatusCode)
+		}
+		if expect == http.StatusOK && (string(body) != "ok" || response.Header.Get("X-Mock") != "1") {
+			t.Errorf("request:%d body:%s header:%v", i, body, response.Header)
+		}
+	}
+	if upstream.Requests("/flaky") != 3 || upstream.Requests("/other") != 0 {
+		t.Errorf("requests:%d", upstream.Requests("/flaky"))
+	}
+}
+
+func Test_MockUpstreamDelayAndDrop(t *testing.T) {
+	upstream := NewMockUpstream()
+	defer upstream.Close()
+	upstream.Script("/slow", MockResponse{Delay: 200 * time.Millisecond})
+	upstream.Script("/drop", MockResponse{Drop: true})
+	pool := NewHTTPConnectionPool(50*time.Millisecond, 2)
+	defer pool.Close()
+	if _, err := pool.Request(newGetRequest(upstream.URL + "/slow")); err != errorRequestCallTimeout {
+		t.Errorf("err:%v", err)
+	}
+	pool.SetTimeout(time.Second)
+	if _, err := pool.Request(newGetRequest(upstream.URL + "/drop")); err == nil {
+		t.Error("expect dropped connection error")
+	}
+}
@@ -0,0 +1,371 @@
+package goutils
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+var defaultTimeout = 3 * time.Second
+
+// HTTPPoolOption http连接池配置项，用于NewHTTPConnectionPoolWithOptions
+type HTTPPoolOption func(*httpPoolOptions)
+
+type httpPoolOptions struct {
+	name                string
+	timeout             time.Duration
+	poolNum             int
+	client              *http.Client
+	transport           http.RoundTripper
+	tlsConfig           *tls.Config
+	proxy               func(*http.Request) (*url.URL, error)
+	dialTimeout         time.Duration
+	keepAlive           time.Duration
+	disableKeepAlives   bool
+	maxIdleConnsPerHost int
+	idleConnTimeout     time.Duration
+	http2               bool
+	h2c                 bool
+	unixSockets         map[string]string
+	checkRedirect       func(req *http.Request, via []*http.Request) error
+	jar                 http.CookieJar
+	admission           HTTPAdmission
+	hedge               *HTTPHedge
+	upstream            *HTTPUpstream
+	maxResponseSize     int64
+	bufferSize          int64
+	rateLimit           *HTTPRateLimit
+	cache               *HTTPCache
+	coalesce            *HTTPCoalesce
+	faults              *HTTPFaultInjector
+	fallback            HTTPFallback
+	bulkheads           *HTTPBulkheads
+	accessLog           *HTTPAccessLog
+	resolver            *HTTPResolver
+}
+
+func newHTTPPoolOptions(options []HTTPPoolOption) *httpPoolOptions {
+	opts := &httpPoolOptions{
+		name:    "default",
+		timeout: defaultTimeout,
+		poolNum: defaultPoolNum,
+		http2:   true, //和http.DefaultTransport一致，设置了DialContext和TLS配置后仍协商HTTP/2
+	}
+	for _, option := range options {
+		option(opts)
+	}
+	if opts.poolNum < 0 {
+		opts.poolNum = defaultPoolNum
+	}
+	if opts.maxIdleConnsPerHost <= 0 {
+		opts.maxIdleConnsPerHost = opts.poolNum * 6
+	}
+	return opts
+}
+
+// newClient 构造连接池使用的http.Client
+// 指定了Client时复制一份使用，超时由连接池按请求控制，Client自身的Timeout不生效
+func (opts *httpPoolOptions) newClient() *http.Client {
+	client := new(http.Client)
+	if opts.client != nil {
+		*client = *opts.client
+	} else {
+		client.Transport = opts.newTransport()
+	}
+	if opts.transport != nil {
+		client.Transport = opts.transport
+	}
+	if opts.checkRedirect != nil {
+		client.CheckRedirect = opts.checkRedirect
+	}
+	if opts.jar != nil {
+		client.Jar = opts.jar
+	}
+	client.Timeout = 0
+	return client
+}
+
+func (opts *httpPoolOptions) newTransport() *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   opts.dialTimeout,
+		KeepAlive: opts.keepAlive,
+	}
+	dialContext := dialer.DialContext
+	if opts.resolver != nil {
+		dialContext = newHTTPResolver(*opts.resolver).dialContext(dialContext)
+	}
+	if len(opts.unixSockets) > 0 {
+		dialContext = unixDialContext(opts.unixSockets, dialer.DialContext, dialContext)
+	}
+	transport := &http.Transport{
+		Proxy:               opts.proxy,
+		DialContext:         dialContext,
+		TLSClientConfig:     opts.tlsConfig,
+		DisableKeepAlives:   opts.disableKeepAlives,
+		MaxIdleConnsPerHost: opts.maxIdleConnsPerHost,
+		IdleConnTimeout:     opts.idleConnTimeout,
+		ForceAttemptHTTP2:   opts.http2,
+	}
+	if opts.h2c {
+		// 只保留HTTP/2，http://地址按prior knowledge使用明文HTTP/2
+		transport.Protocols = new(http.Protocols)
+		transport.Protocols.SetHTTP2(true)
+		transport.Protocols.SetUnencryptedHTTP2(true)
+	}
+	return transport
+}
+
+// unixDialContext 地址(host:port或者host)配置了unix socket时连接socket文件，否则使用dial
+func unixDialContext(sockets map[string]string, unixDial, dial func(ctx context.Context, network, address string) (net.Conn, error)) func(ctx context.Context, network, address string) (net.Conn, error) {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		path, ok := sockets[address]
+		if !ok {
+			if host, _, err := net.SplitHostPort(address); err == nil {
+				path, ok = sockets[host]
+			}
+		}
+		if ok {
+			return unixDial(ctx, "unix", path)
+		}
+		return dial(ctx, network, address)
+	}
+}
+
+// WithName 设置连接池名字
+func WithName(name string) HTTPPoolOption {
+	return func(opts *httpPoolOptions) {
+		opts.name = name
+	}
+}
+
+// WithTimeout 设置请求超时时间，默认3s
+func WithTimeout(timeout time.Duration) HTTPPoolOption {
+	return func(opts *httpPoolOptions) {
+		opts.timeout = timeout
+	}
+}
+
+// WithPoolNum 设置worker数目，小于0时使用默认值100
+func WithPoolNum(poolNum int) HTTPPoolOption {
+	return func(opts *httpPoolOptions) {
+		opts.poolNum = poolNum
+	}
+}
+
+// WithClient 使用自定义的http.Client，Timeout不生效，以连接池超时时间为准，
+// 此时TLS、代理、拨号等transport相关配置不生效
+func WithClient(client *http.Client) HTTPPoolOption {
+	return func(opts *httpPoolOptions) {
+		opts.client = client
+	}
+}
+
+// WithTransport 使用自定义的RoundTripper，此时TLS、代理、拨号等transport相关配置不生效
+func WithTransport(transport http.RoundTripper) HTTPPoolOption {
+	return func(opts *httpPoolOptions) {
+		opts.transport = transport
+	}
+}
+
+// WithTLSConfig 设置TLS配置
+func WithTLSConfig(config *tls.Config) HTTPPoolOption {
+	return func(opts *httpPoolOptions) {
+		opts.tlsConfig = config
+	}
+}
+
+// WithProxy 设置代理，如http.ProxyFromEnvironment或http.ProxyURL(u)
+func WithProxy(proxy func(*http.Request) (*url.URL, error)) HTTPPoolOption {
+	return func(opts *httpPoolOptions) {
+		opts.proxy = proxy
+	}
+}
+
+// WithDialTimeout 设置建立连接超时时间
+func WithDialTimeout(timeout time.Duration) HTTPPoolOption {
+	return func(opts *httpPoolOptions) {
+		opts.dialTimeout = timeout
+	}
+}
+
+// WithKeepAlive 设置tcp keep-alive探测间隔，小于0时关闭
+func WithKeepAlive(keepAlive time.Duration) HTTPPoolOption {
+	return func(opts *httpPoolOptions) {
+		opts.keepAlive = keepAlive
+	}
+}
+
+// WithDisableKeepAlives 关闭http长连接，每个请求使用新连接
+func WithDisableKeepAlives() HTTPPoolOption {
+	return func(opts *httpPoolOptions) {
+		opts.disableKeepAlives = true
+	}
+}
+
+// WithMaxIdleConnsPerHost 设置每个host的最大空闲连接数，默认poolNum*6
+func WithMaxIdleConnsPerHost(num int) HTTPPoolOption {
+	return func(opts *httpPoolOptions) {
+		opts.maxIdleConnsPerHost = num
+	}
+}
+
+// WithIdleConnTimeout 设置空闲连接的保留时间
+func WithIdleConnTimeout(timeout time.Duration) HTTPPoolOption {
+	return func(opts *httpPoolOptions) {
+		opts.idleConnTimeout = timeout
+	}
+}
+
+// WithHTTP2 TLS连接协商HTTP/2，内置Transport默认已开启，保留用于兼容
+func WithHTTP2() HTTPPoolOption {
+	return func(opts *httpPoolOptions) {
+		opts.http2 = true
+	}
+}
+
+// WithH2C http://地址使用明文HTTP/2(prior knowledge)，开启后连接池只使用HTTP/2，https地址也不会回退到HTTP/1.1
+func WithH2C() HTTPPoolOption {
+	return func(opts *httpPoolOptions) {
+		opts.h2c = true
+	}
+}
+
+// WithUnixSocket 请求host(可以带端口)时连接unix socket文件，可多次调用设置多个host
+func WithUnixSocket(host, path string) HTTPPoolOption {
+	return func(opts *httpPoolOptions) {
+		if opts.unixSockets == nil {
+			opts.unixSockets = make(map[string]string)
+		}
+		opts.unixSockets[host] = path
+	}
+}
+
+// WithCheckRedirect 设置重定向策略，参考http.Client.CheckRedirect
+func WithCheckRedirect(checkRedirect func(req *http.Request, via []*http.Request) error) HTTPPoolOption {
+	return func(opts *httpPoolOptions) {
+		opts.checkRedirect = checkRedirect
+	}
+}
+
+// WithCookieJar 设置cookie jar
+func WithCookieJar(jar http.CookieJar) HTTPPoolOption {
+	return func(opts *httpPoolOptions) {
+		opts.jar = jar
+	}
+}
+
+// WithAdmission 设置连接池满时的准入策略，默认立即拒绝
+func WithAdmission(admission HTTPAdmission) HTTPPoolOption {
+	return func(opts *httpPoolOptions) {
+		opts.admission = admission
+	}
+}
+
+// WithHedge 开启对冲请求
+func WithHedge(hedge HTTPHedge) HTTPPoolOption {
+	return func(opts *httpPoolOptions) {
+		opts.hedge = &hedge
+	}
+}
+
+// WithUpstream 设置后端节点，请求按负载均衡策略发往其中一个节点
+func WithUpstream(upstream *HTTPUpstream) HTTPPoolOption {
+	return func(opts *httpPoolOptions) {
+		opts.upstream = upstream
+	}
+}
+
+// WithMaxResponseSize 设置DoJSON等方法读取响应的最大字节数，默认10MB
+func WithMaxResponseSize(size int64) HTTPPoolOption {
+	return func(opts *httpPoolOptions) {
+		opts.maxResponseSize = size
+	}
+}
+
+// WithBufferedResponse 开启后worker把响应body完整读到内存中再返回，超过maxSize时返回错误
+func WithBufferedResponse(maxSize int64) HTTPPoolOption {
+	return func(opts *httpPoolOptions) {
+		opts.bufferSize = maxSize
+	}
+}
+
+// WithRateLimit 设置连接池和每个host的限流
+func WithRateLimit(limit HTTPRateLimit) HTTPPoolOption {
+	return func(opts *httpPoolOptions) {
+		opts.rateLimit = &limit
+	}
+}
+
+// WithCache 开启响应缓存
+func WithCache(cache HTTPCache) HTTPPoolOption {
+	return func(opts *httpPoolOptions) {
+		opts.cache = &cache
+	}
+}
+
+// WithCoalesce 开启相同请求合并
+func WithCoalesce(coalesce HTTPCoalesce) HTTPPoolOption {
+	return func(opts *httpPoolOptions) {
+		opts.coalesce = &coalesce
+	}
+}
+
+// SetTransport 运行时替换连接池使用的RoundTripper，比如注入Cassette或者测试用的transport，
+// 对之后发出的请求生效
+func (cp *HTTPConnectionPool) SetTransport(transport http.RoundTripper) {
+	cp.lock.Lock()
+	client := new(http.Client)
+	*client = *cp.httpClient
+	client.Transport = transport
+	old := cp.httpClient
+	cp.httpClient = client
+	cp.lock.Unlock()
+	old.CloseIdleConnections()
+}
+
+// Transport 连接池当前使用的RoundTripper，可用于健康检查等需要和连接池使用同样连接配置的场景
+func (cp *HTTPConnectionPool) Transport() http.RoundTripper {
+	transport := cp.getClient().Transport
+	if transport == nil {
+		return http.DefaultTransport
+	}
+	return transport
+}
+
+// WithFaultInjector 设置故障注入
+func WithFaultInjector(f *HTTPFaultInjector) HTTPPoolOption {
+	return func(opts *httpPoolOptions) {
+		opts.faults = f
+	}
+}
+
+// WithFallback 设置请求被拒绝、超时或者熔断时的降级处理
+func WithFallback(fallback HTTPFallback) HTTPPoolOption {
+	return func(opts *httpPoolOptions) {
+		opts.fallback = fallback
+	}
+}
+
+// WithBulkheads 设置分区隔离
+func WithBulkheads(bulkheads HTTPBulkheads) HTTPPoolOption {
+	return func(opts *httpPoolOptions) {
+		opts.bulkheads = &bulkheads
+	}
+}
+
+// WithAccessLog 设置出站请求访问日志
+func WithAccessLog(accessLog HTTPAccessLog) HTTPPoolOption {
+	return func(opts *httpPoolOptions) {
+		opts.accessLog = &accessLog
+	}
+}
+
+// WithResolver 设置带缓存的DNS解析，指定了Client或者Transport时不生效
+func WithResolver(resolver HTTPResolver) HTTPPoolOption {
+	return func(opts *httpPoolOptions) {
+		opts.resolver = &resolver
+	}
+}
@@ -0,0 +1,159 @@
+package goutils
+
+import (
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_HTTPOptionsDefault(t *testing.T) {
+	pool := NewHTTPConnectionPoolWithOptions()
+	if pool.GetPoolNum() != defaultPoolNum || pool.GetTimeout() != defaultTimeout {
+		t.Fail()
+	}
+	transport, ok := pool.getClient().Transport.(*http.Transport)
+	if !ok || transport.MaxIdleConnsPerHost != defaultPoolNum*6 || !transport.ForceAttemptHTTP2 {
+		t.Fail()
+	}
+	pool.Close()
+}
+
+func Test_HTTPOptionsTransport(t *testing.T) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: true}
+	pool := NewHTTPConnectionPoolWithOptions(
+		WithName("options"),
+		WithTimeout(time.Second),
+		WithPoolNum(2),
+		WithTLSConfig(tlsConfig),
+		WithProxy(http.ProxyFromEnvironment),
+		WithDialTimeout(time.Second),
+		WithMaxIdleConnsPerHost(4),
+		WithHTTP2(),
+	)
+	defer pool.Close()
+	client := pool.getClient()
+	transport := client.Transport.(*http.Transport)
+	if transport.TLSClientConfig != tlsConfig || transport.MaxIdleConnsPerHost != 4 || !transport.ForceAttemptHTTP2 || transport.Proxy == nil {
+		t.Fail()
+	}
+	if pool.GetTimeout() != time.Second || pool.name != "options" {
+		t.Fail()
+	}
+}
+
+func Test_HTTPOptionsDefaultHTTP2(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	}))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+	tlsConfig := server.Client().Transport.(*http.Transport).TLSClientConfig
+	pool := NewHTTPConnectionPoolWithOptions(WithTimeout(time.Second), WithPoolNum(1), WithTLSConfig(tlsConfig), WithDialTimeout(time.Second))
+	defer pool.Close()
+	httpData := NewHTTPData(newGetRequest(server.URL))
+	pool.Do(httpData)
+	if proto := readProto(t, httpData); proto != "HTTP/2.0" {
+		t.Errorf("unexpected proto %s", proto)
+	}
+}
+
+func Test_HTTPOptionsClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "/", http.StatusFound)
+		}
+	}))
+	defer server.Close()
+	errRedirect := errors.New("redirect")
+	jar, _ := cookiejar.New(nil)
+	pool := NewHTTPConnectionPoolWithOptions(
+		WithTimeout(time.Second),
+		WithPoolNum(1),
+		WithClient(&http.Client{Timeout: time.Minute}),
+		WithCheckRedirect(func(req *http.Request, via []*http.Request) error {
+			return errRedirect
+		}),
+		WithCookieJar(jar),
+	)
+	defer pool.Close()
+	client := pool.getClient()
+	if client.Timeout != 0 || client.Jar != jar {
+		t.Fail()
+	}
+	request, _ := http.NewRequest("GET", server.URL+"/redirect", nil)
+	if _, err := pool.Request(request); !errors.Is(err, errRedirect) {
+		t.Errorf("request err:%v", err)
+	}
+}
+
+func newProtoServer(listener net.Listener, h2c bool) *http.Server {
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	})}
+	if h2c {
+		server.Protocols = new(http.Protocols)
+		server.Protocols.SetUnencryptedHTTP2(true)
+	}
+	go server.Serve(listener)
+	return server
+}
+
+func readProto(t *testing.T, httpData *HTTPData) string {
+	if httpData.Err != nil {
+		t.Fatal(httpData.Err.Error())
+	}
+	body, _ := io.ReadAll(httpData.Response.Body)
+	httpData.Response.Body.Close()
+	return string(body)
+}
+
+func Test_HTTPOptionsUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sidecar.sock")
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	server := newProtoServer(listener, false)
+	defer server.Close()
+	pool := NewHTTPConnectionPoolWithOptions(WithTimeout(time.Second), WithPoolNum(2), WithUnixSocket("sidecar", path))
+	defer pool.Close()
+	httpDatas := []*HTTPData{NewHTTPData(newGetRequest("http://sidecar/a")), NewHTTPData(newGetRequest("http://sidecar:8080/b"))}
+	pool.BatchRequest(httpDatas)
+	for _, httpData := range httpDatas {
+		if proto := readProto(t, httpData); proto != "HTTP/1.1" {
+			t.Errorf("unexpected proto %s", proto)
+		}
+	}
+	if _, err = pool.Request(newGetRequest("http://127.0.0.1:1/")); err == nil {
+		t.Error("hosts without socket should dial tcp")
+	}
+}
+
+func Test_HTTPOptionsH2C(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	server := newProtoServer(listener, true)
+	defer server.Close()
+	url := "http://" + listener.Addr().String() + "/"
+
+	pool := NewHTTPConnectionPoolWithOptions(WithTimeout(time.Second), WithPoolNum(2), WithH2C())
+	defer pool.Close()
+	httpDatas := []*HTTPData{NewHTTPData(newGetRequest(url)), NewHTTPData(newGetRequest(url)), NewHTTPData(newGetRequest(url))}
+	pool.BatchRequest(httpDatas[:2])
+	pool.Do(httpDatas[2])
+	for _, httpData := range httpDatas {
+		if proto := readProto(t, httpData); proto != "HTTP/2.0" {
+			t.Errorf("unexpected proto %s", proto)
+		}
+	}
+}
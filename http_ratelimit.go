@@ -0,0 +1,292 @@
+package goutils
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	defaultMaxRetryAfter = time.Minute
+	defaultRetryAfter    = time.Second
+	slowdownMinRatio     = 1.0 / 16 //限速后速率的下限占配置速率的比例
+	slowdownRecoverStep  = 1.0 / 20 //每个成功响应恢复的速率占配置速率的比例
+)
+
+// HTTPRateLimit 令牌桶限流配置，连接池和每个host分别限流，请求需要同时拿到两者的令牌
+type HTTPRateLimit struct {
+	Rate          float64                 //连接池每秒请求数，0表示不限制
+	Burst         int                     //连接池令牌桶容量，默认为Rate向上取整
+	HostRate      float64                 //每个host每秒请求数，0表示不限制
+	HostBurst     int                     //每个host令牌桶容量，默认为HostRate向上取整
+	Hosts         map[string]HTTPHostRate //单独设置限流的host，key为URL中的host，带端口时包含端口
+	Wait          bool                    //没有令牌时等待，最多等到请求的截止时间，false时立即返回限流错误
+	RetryAfter    bool                    //收到429或者503时按Retry-After暂停该host，并把该host的速率减半，之后逐步恢复
+	MaxRetryAfter time.Duration           //暂停时间上限，默认1分钟
+}
+
+// HTTPHostRate 单个host的限流配置
+type HTTPHostRate struct {
+	Rate  float64
+	Burst int
+}
+
+// tokenBucket 令牌桶，令牌可以为负数，表示已经预约了之后产生的令牌
+type tokenBucket struct {
+	lock        *sync.Mutex
+	limit       float64 //配置的速率
+	rate        float64 //当前速率，被限速时低于limit
+	burst       float64
+	tokens      float64
+	last        time.Time
+	pausedUntil time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if burst <= 0 {
+		burst = int(math.Ceil(rate))
+	}
+	if burst <= 0 {
+		burst = 1
+	}
+	return &tokenBucket{
+		lock:   new(sync.Mutex),
+		limit:  rate,
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+func (b *tokenBucket) advance(now time.Time) {
+	if now.After(b.last) {
+		b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+		b.last = now
+	}
+}
+
+// reserve 预约一个令牌，返回需要等待的时间，等待时间超过maxWait时不预约并返回false
+func (b *tokenBucket) reserve(now time.Time, maxWait time.Duration) (time.Duration, bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	var wait time.Duration
+	if b.pausedUntil.After(now) {
+		wait = b.pausedUntil.Sub(now)
+	}
+	if b.rate > 0 {
+		b.advance(now)
+		if b.tokens < 1 {
+			if tokenWait := time.Duration((1 - b.tokens) / b.rate * float64(time.Second)); tokenWait > wait {
+				wait = tokenWait
+			}
+		}
+	}
+	if wait > maxWait {
+		return wait, false
+	}
+	if b.rate > 0 {
+		b.tokens--
+	}
+	return wait, true
+}
+
+// cancel 归还预约的令牌
+func (b *tokenBucket) cancel() {
+	b.lock.Lock()
+	if b.rate > 0 {
+		b.tokens = math.Min(b.burst, b.tokens+1)
+	}
+	b.lock.Unlock()
+}
+
+// slowdown 暂停到now+pause，并把速率减半
+func (b *tokenBucket) slowdown(now time.Time, pause time.Duration) {
+	b.lock.Lock()
+	if until := now.Add(pause); until.After(b.pausedUntil) {
+		b.pausedUntil = until
+	}
+	if b.limit > 0 {
+		b.advance(now)
+		b.rate = math.Max(b.rate/2, b.limit*slowdownMinRatio)
+	}
+	b.lock.Unlock()
+}
+
+// speedup 成功响应后逐步恢复速率
+func (b *tokenBucket) speedup(now time.Time) {
+	b.lock.Lock()
+	if b.rate < b.limit {
+		b.advance(now)
+		b.rate = math.Min(b.limit, b.rate+b.limit*slowdownRecoverStep)
+	}
+	b.lock.Unlock()
+}
+
+type httpLimiter struct {
+	config HTTPRateLimit
+	pool   *tokenBucket
+	lock   *sync.Mutex
+	hosts  map[string]*tokenBucket
+}
+
+// host 获取host的令牌桶，没有配置限流的host在create为false时返回nil
+func (l *httpLimiter) host(host string, create bool) *tokenBucket {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if b, ok := l.hosts[host]; ok {
+		return b
+	}
+	rate, burst := l.config.HostRate, l.config.HostBurst
+	if hostRate, ok := l.config.Hosts[host]; ok {
+		rate, burst = hostRate.Rate, hostRate.Burst
+	}
+	if rate <= 0 && !create {
+		return nil
+	}
+	b := newTokenBucket(math.Max(rate, 0), burst)
+	l.hosts[host] = b
+	return b
+}
+
+// reserve 从连接池和host的令牌桶各预约一个令牌，任何一个等待时间超过maxWait时都不预约
+func (l *httpLimiter) reserve(host string, now time.Time, maxWait time.Duration) ([]*tokenBucket, time.Duration, bool) {
+	var buckets []*tokenBucket
+	var wait time.Duration
+	for _, b := range []*tokenBucket{l.pool, l.host(host, false)} {
+		if b == nil {
+			continue
+		}
+		w, ok := b.reserve(now, maxWait)
+		if !ok {
+			for _, reserved := range buckets {
+				reserved.cancel()
+			}
+			return nil, w, false
+		}
+		buckets = append(buckets, b)
+		if w > wait {
+			wait = w
+		}
+	}
+	return buckets, wait, true
+}
+
+// observe 根据响应调整host的速率
+func (l *httpLimiter) observe(host string, response *http.Response) {
+	if !l.config.RetryAfter || response == nil {
+		return
+	}
+	now := time.Now()
+	if response.StatusCode == http.StatusTooManyRequests || response.StatusCode == http.StatusServiceUnavailable {
+		pause, ok := parseRetryAfter(response.Header.Get("Retry-After"), now)
+		if !ok {
+			if response.StatusCode != http.StatusTooManyRequests {
+				return
+			}
+			pause = defaultRetryAfter
+		}
+		if pause > l.config.MaxRetryAfter {
+			pause = l.config.MaxRetryAfter
+		}
+		l.host(host, true).slowdown(now, pause)
+		Log.Warning("http host:%s rate limited by server, status:%d, pause:%v", host, response.StatusCode, pause)
+		return
+	}
+	if response.StatusCode < http.StatusBadRequest {
+		if b := l.host(host, false); b != nil {
+			b.speedup(now)
+		}
+	}
+}
+
+// parseRetryAfter 解析Retry-After，支持秒数和http时间两种格式
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	date, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	if pause := date.Sub(now); pause > 0 {
+		return pause, true
+	}
+	return 0, true
+}
+
+// SetRateLimit 设置限流配置，nil表示关闭，重新设置时令牌桶和限速状态会被重置
+func (cp *HTTPConnectionPool) SetRateLimit(limit *HTTPRateLimit) {
+	var limiter *httpLimiter
+	if limit != nil {
+		config := *limit
+		if config.MaxRetryAfter <= 0 {
+			config.MaxRetryAfter = defaultMaxRetryAfter
+		}
+		limiter = &httpLimiter{config: config, lock: new(sync.Mutex), hosts: make(map[string]*tokenBucket)}
+		if config.Rate > 0 {
+			limiter.pool = newTokenBucket(config.Rate, config.Burst)
+		}
+	}
+	cp.lock.Lock()
+	cp.limiter = limiter
+	cp.lock.Unlock()
+}
+
+func (cp *HTTPConnectionPool) getLimiter() *httpLimiter {
+	cp.lock.Lock()
+	defer cp.lock.Unlock()
+	return cp.limiter
+}
+
+// rateLimit 入队前获取令牌，需要等待时最多等到请求的截止时间
+func (cp *HTTPConnectionPool) rateLimit(httpData *HTTPData) error {
+	limiter := cp.getLimiter()
+	if limiter == nil || httpData.Request == nil {
+		return nil
+	}
+	now := time.Now()
+	var maxWait time.Duration
+	if limiter.config.Wait {
+		maxWait = httpData.deadline.Sub(now)
+	}
+	buckets, wait, ok := limiter.reserve(httpData.Request.URL.Host, now, maxWait)
+	if !ok {
+		atomic.AddInt64(&cp.stats.rateLimitedNum, 1)
+		return errorRequestRateLimited
+	}
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	var err error
+	select {
+	case <-timer.C:
+		return nil
+	case <-httpData.requestContext().Done():
+		err = httpData.requestContext().Err()
+	case <-cp.closing:
+		err = errorRequestPoolClosed
+	}
+	for _, b := range buckets {
+		b.cancel()
+	}
+	return err
+}
+
+// observeRateLimit worker收到响应后调用
+func (cp *HTTPConnectionPool) observeRateLimit(request *http.Request, response *http.Response) {
+	if limiter := cp.getLimiter(); limiter != nil {
+		limiter.observe(request.URL.Host, response)
+	}
+}
@@ -0,0 +1,162 @@
+package goutils
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_TokenBucket(t *testing.T) {
+	b := newTokenBucket(10, 2)
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		if wait, ok := b.reserve(now, 0); !ok || wait != 0 {
+			t.Errorf("burst reserve %d wait:%v ok:%v", i, wait, ok)
+		}
+	}
+	if _, ok := b.reserve(now, 0); ok {
+		t.Error("expect no token")
+	}
+	wait, ok := b.reserve(now, time.Second)
+	if !ok || wait < 90*time.Millisecond || wait > 110*time.Millisecond {
+		t.Errorf("wait:%v ok:%v", wait, ok)
+	}
+	b.cancel()
+	if _, ok := b.reserve(now.Add(100*time.Millisecond), 0); !ok {
+		t.Error("expect token after refill")
+	}
+}
+
+func Test_TokenBucketSlowdown(t *testing.T) {
+	b := newTokenBucket(100, 1)
+	now := time.Now()
+	b.slowdown(now, time.Second)
+	if b.rate != 50 {
+		t.Errorf("rate:%v", b.rate)
+	}
+	if wait, ok := b.reserve(now, 2*time.Second); !ok || wait != time.Second {
+		t.Errorf("wait:%v ok:%v", wait, ok)
+	}
+	for i := 0; i < 20; i++ {
+		b.speedup(now)
+	}
+	if b.rate != 100 {
+		t.Errorf("rate:%v", b.rate)
+	}
+}
+
+func Test_ParseRetryAfter(t *testing.T) {
+	now := time.Now()
+	if pause, ok := parseRetryAfter("3", now); !ok || pause != 3*time.Second {
+		t.Errorf("pause:%v ok:%v", pause, ok)
+	}
+	date := now.Add(10 * time.Second).UTC().Format(http.TimeFormat)
+	if pause, ok := parseRetryAfter(date, now); !ok || pause <= 8*time.Second || pause > 10*time.Second {
+		t.Errorf("pause:%v ok:%v", pause, ok)
+	}
+	if _, ok := parseRetryAfter("soon", now); ok {
+		t.Fail()
+	}
+}
+
+func Test_HTTPRateLimitReject(t *testing.T) {
+	server := newNamedServer("a", http.StatusOK)
+	defer server.Close()
+	pool := NewHTTPConnectionPoolWithOptions(WithTimeout(time.Second), WithPoolNum(4),
+		WithRateLimit(HTTPRateLimit{Rate: 1, Burst: 2}))
+	defer pool.Close()
+	for i := 0; i < 2; i++ {
+		if _, err := requestBody(pool, server.URL); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	if _, err := requestBody(pool, server.URL); err != errorRequestRateLimited {
+		t.Errorf("err:%v", err)
+	}
+	if stats := pool.loadStats(); stats.rateLimitedNum != 1 {
+		t.Errorf("rateLimitedNum:%d", stats.rateLimitedNum)
+	}
+}
+
+func Test_HTTPRateLimitWait(t *testing.T) {
+	server := newNamedServer("a", http.StatusOK)
+	defer server.Close()
+	pool := NewHTTPConnectionPool(time.Second, 4)
+	defer pool.Close()
+	pool.SetRateLimit(&HTTPRateLimit{HostRate: 20, HostBurst: 1, Wait: true})
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if _, err := requestBody(pool, server.URL); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	if cost := time.Since(start); cost < 90*time.Millisecond {
+		t.Errorf("cost:%v", cost)
+	}
+	// 其他host不限流
+	other := newNamedServer("b", http.StatusOK)
+	defer other.Close()
+	pool.SetRateLimit(&HTTPRateLimit{Hosts: map[string]HTTPHostRate{serverAddr(server): {Rate: 1}}})
+	requestBody(pool, server.URL)
+	if _, err := requestBody(pool, server.URL); err != errorRequestRateLimited {
+		t.Errorf("err:%v", err)
+	}
+	if _, err := requestBody(pool, other.URL); err != nil {
+		t.Errorf("err:%v", err)
+	}
+}
+
+func Test_HTTPRateLimitWaitDeadline(t *testing.T) {
+	server := newNamedServer("a", http.StatusOK)
+	defer server.Close()
+	pool := NewHTTPConnectionPool(50*time.Millisecond, 4)
+	defer pool.Close()
+	pool.SetRateLimit(&HTTPRateLimit{Rate: 1, Wait: true})
+	requestBody(pool, server.URL)
+	if _, err := requestBody(pool, server.URL); err != errorRequestRateLimited {
+		t.Errorf("err:%v", err)
+	}
+	pool.SetTimeout(2 * time.Second)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	request, _ := http.NewRequestWithContext(ctx, "GET", server.URL, nil)
+	if _, err := pool.Request(request); err != context.Canceled {
+		t.Errorf("err:%v", err)
+	}
+}
+
+func Test_HTTPRateLimitRetryAfter(t *testing.T) {
+	var count int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt64(&count, 1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	defer server.Close()
+	pool := NewHTTPConnectionPool(time.Second, 4)
+	defer pool.Close()
+	pool.SetRateLimit(&HTTPRateLimit{RetryAfter: true, MaxRetryAfter: 100 * time.Millisecond})
+	response, err := pool.Request(newGetRequest(server.URL))
+	if err != nil || response.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("response:%v err:%v", response, err)
+	}
+	CloseResponse(response)
+	if _, err := pool.Request(newGetRequest(server.URL)); err != errorRequestRateLimited {
+		t.Errorf("err:%v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	response, err = pool.Request(newGetRequest(server.URL))
+	if err != nil || response.StatusCode != http.StatusOK {
+		t.Errorf("response:%v err:%v", response, err)
+	}
+	CloseResponse(response)
+}
+
+func newGetRequest(url string) *http.Request {
+	request, _ := http.NewRequest("GET", url, nil)
+	return request
+}
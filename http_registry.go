@@ -0,0 +1,262 @@
+package goutils
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// recentErrorNum 每个连接池保留的最近错误数
+const recentErrorNum = 20
+
+// httpPoolRegistry 全局连接池注册表，连接池构造时注册，关闭时注销
+type httpPoolRegistry struct {
+	lock  *sync.Mutex
+	pools map[*HTTPConnectionPool]bool
+}
+
+var httpPools = &httpPoolRegistry{lock: new(sync.Mutex), pools: make(map[*HTTPConnectionPool]bool)}
+
+func (r *httpPoolRegistry) register(cp *HTTPConnectionPool) {
+	r.lock.Lock()
+	r.pools[cp] = true
+	r.lock.Unlock()
+}
+
+func (r *httpPoolRegistry) unregister(cp *HTTPConnectionPool) {
+	r.lock.Lock()
+	delete(r.pools, cp)
+	r.lock.Unlock()
+}
+
+// HTTPPools 返回所有未关闭的连接池，按名字排序
+func HTTPPools() []*HTTPConnectionPool {
+	httpPools.lock.Lock()
+	pools := make([]*HTTPConnectionPool, 0, len(httpPools.pools))
+	for cp := range httpPools.pools {
+		pools = append(pools, cp)
+	}
+	httpPools.lock.Unlock()
+	sort.SliceStable(pools, func(i, j int) bool {
+		return pools[i].GetName() < pools[j].GetName()
+	})
+	return pools
+}
+
+// LookupHTTPPool 按名字查找未关闭的连接池，同名时返回任意一个，找不到时返回nil
+func LookupHTTPPool(name string) *HTTPConnectionPool {
+	for _, cp := range HTTPPools() {
+		if cp.GetName() == name {
+			return cp
+		}
+	}
+	return nil
+}
+
+// HTTPPoolError 连接池最近的一次请求错误
+type HTTPPoolError struct {
+	Time   time.Time `json:"time"`
+	Method string    `json:"method"`
+	Host   string    `json:"host"`
+	Path   string    `json:"path"` //不包含query，避免泄露敏感参数
+	Status int       `json:"status"`
+	Error  string    `json:"error"` //错误中的URL同样不包含query
+}
+
+// httpErrorRing 保存最近的错误，写满后覆盖最早的
+type httpErrorRing struct {
+	lock   *sync.Mutex
+	errors []HTTPPoolError
+	next   int
+}
+
+func newHTTPErrorRing(size int) *httpErrorRing {
+	return &httpErrorRing{lock: new(sync.Mutex), errors: make([]HTTPPoolError, 0, size)}
+}
+
+func (r *httpErrorRing) add(e HTTPPoolError) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if len(r.errors) < cap(r.errors) {
+		r.errors = append(r.errors, e)
+		return
+	}
+	r.errors[r.next] = e
+	r.next = (r.next + 1) % len(r.errors)
+}
+
+// list 按时间从新到旧返回
+func (r *httpErrorRing) list() []HTTPPoolError {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	list := make([]HTTPPoolError, 0, len(r.errors))
+	for i := len(r.errors) - 1; i >= 0; i-- {
+		list = append(list, r.errors[(r.next+i)%len(r.errors)])
+	}
+	return list
+}
+
+// recordError 请求失败或者返回5xx时记录到最近错误中
+func (cp *HTTPConnectionPool) recordError(httpData *HTTPData) {
+	if httpData.Request == nil {
+		return
+	}
+	e := HTTPPoolError{Time: time.Now(), Method: httpData.Request.Method, Host: httpData.Request.URL.Host, Path: httpData.Request.URL.Path}
+	switch {
+	case httpData.Err != nil:
+		e.Error = redactURLError(httpData.Err, stripQuery).Error()
+	case httpData.FallbackFrom != nil:
+		e.Error = "fallback: " + redactURLError(httpData.FallbackFrom, stripQuery).Error()
+	case httpData.Response != nil && httpData.Response.StatusCode >= 500:
+		e.Status = httpData.Response.StatusCode
+		e.Error = http.StatusText(e.Status)
+	default:
+		return
+	}
+	if httpData.Response != nil {
+		e.Status = httpData.Response.StatusCode
+	}
+	cp.recentErrors.add(e)
+}
+
+// HTTPEndpointInfo 后端节点状态，State为healthy、unhealthy或者ejected
+type HTTPEndpointInfo struct {
+	Addr        string `json:"addr"`
+	State       string `json:"state"`
+	Outstanding int64  `json:"outstanding"`
+}
+
+// HTTPPoolInfo 连接池的配置和实时状态，用于调试页面
+type HTTPPoolInfo struct {
+	Name           string               `json:"name"`
+	Timeout        string               `json:"timeout"`
+	PoolNum        int                  `json:"pool_num"`
+	WorkerNum      int                  `json:"worker_num"`
+	ActiveNum      int64                `json:"active_num"`  //正在执行的请求数
+	Utilization    float64              `json:"utilization"` //ActiveNum/PoolNum
+	QueueDepth     int                  `json:"queue_depth"` //队列中和等待入队的请求数
+	Admission      string               `json:"admission"`
+	Features       []string             `json:"features"` //开启的功能
+	DoneNum        int64                `json:"done_num"`
+	TimeoutNum     int64                `json:"timeout_num"`
+	PoolFullNum    int64                `json:"pool_full_num"`
+	RateLimitedNum int64                `json:"rate_limited_num"`
+	FallbackNum    int64                `json:"fallback_num"`
+	Endpoints      []HTTPEndpointInfo   `json:"endpoints,omitempty"`
+	Partitions     []HTTPPartitionStats `json:"partitions,omitempty"`
+	RecentErrors   []HTTPPoolError      `json:"recent_errors"`
+}
+
+var admissionNames = map[HTTPAdmissionPolicy]string{
+	AdmissionReject: "reject",
+	AdmissionWait:   "wait",
+	AdmissionLIFO:   "lifo",
+	AdmissionCoDel:  "codel",
+}
+
+// Info 返回连接池的配置和实时状态，统计为累计值，不影响Status的增量统计
+func (cp *HTTPConnectionPool) Info() HTTPPoolInfo {
+	cp.lock.Lock()
+	info := HTTPPoolInfo{
+		Name:      cp.name,
+		Timeout:   cp.timeout.String(),
+		PoolNum:   cp.poolNum,
+		WorkerNum: cp.workerNum,
+		Admission: admissionNames[cp.admission.Policy],
+	}
+	features := []struct {
+		name    string
+		enabled bool
+	}{
+		{"adaptive", cp.adaptive != nil},
+		{"hedge", cp.hedger != nil},
+		{"upstream", cp.upstream != nil},
+		{"rate_limit", cp.limiter != nil},
+		{"cache", cp.cache != nil},
+		{"coalesce", cp.coalescer != nil},
+		{"fault", cp.faults != nil},
+		{"fallback", cp.fallback != nil},
+		{"bulkhead", cp.bulkheads != nil},
+		{"access_log", cp.accessLog != nil},
+		{"interceptor", len(cp.interceptors) > 0},
+		{"buffered", cp.bufferSize > 0},
+	}
+	cp.lock.Unlock()
+	info.Features = []string{}
+	for _, feature := range features {
+		if feature.enabled {
+			info.Features = append(info.Features, feature.name)
+		}
+	}
+	info.ActiveNum = atomic.LoadInt64(&cp.activeNum)
+	if info.PoolNum > 0 {
+		info.Utilization = float64(info.ActiveNum) / float64(info.PoolNum)
+	}
+	info.QueueDepth = len(cp.requestPool) + cp.waitQueue.len()
+	stats := cp.loadStats()
+	info.DoneNum = stats.doneNum
+	info.TimeoutNum = stats.timeoutNum
+	info.PoolFullNum = stats.poolFullNum
+	info.RateLimitedNum = stats.rateLimitedNum
+	info.FallbackNum = stats.fallbackNum
+	if upstream := cp.getUpstream(); upstream != nil {
+		for _, ep := range upstream.Endpoints() {
+			info.Endpoints = append(info.Endpoints, HTTPEndpointInfo{Addr: ep.Addr, State: ep.state(), Outstanding: ep.Outstanding()})
+		}
+	}
+	info.Partitions = cp.PartitionStats()
+	info.RecentErrors = cp.recentErrors.list()
+	return info
+}
+
+var httpPoolsTemplate = template.Must(template.New("pools").Funcs(template.FuncMap{
+	"mul100": func(v float64) float64 { return v * 100 },
+}).Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>http pools</title>
+<style>body{font-family:monospace}table{border-collapse:collapse;margin-bottom:1em}td,th{border:1px solid #ccc;padding:2px 6px;text-align:left}</style>
+</head><body>
+{{range .}}<h2>{{.Name}}</h2>
+<table>
+<tr><th>timeout</th><td>{{.Timeout}}</td><th>admission</th><td>{{.Admission}}</td></tr>
+<tr><th>workers</th><td>{{.ActiveNum}} active / {{.WorkerNum}} running / {{.PoolNum}} configured ({{printf "%.0f" (mul100 .Utilization)}}%)</td><th>queue</th><td>{{.QueueDepth}}</td></tr>
+<tr><th>done</th><td>{{.DoneNum}}</td><th>timeout / pool full / rate limited / fallback</th><td>{{.TimeoutNum}} / {{.PoolFullNum}} / {{.RateLimitedNum}} / {{.FallbackNum}}</td></tr>
+<tr><th>features</th><td colspan="3">{{range .Features}}{{.}} {{end}}</td></tr>
+</table>
+{{if .Endpoints}}<table><tr><th>endpoint</th><th>state</th><th>outstanding</th></tr>
+{{range .Endpoints}}<tr><td>{{.Addr}}</td><td>{{.State}}</td><td>{{.Outstanding}}</td></tr>
+{{end}}</table>{{end}}
+{{if .Partitions}}<table><tr><th>partition</th><th>active</th><th>waiting</th><th>max</th><th>total</th><th>rejected</th></tr>
+{{range .Partitions}}<tr><td>{{.Name}}</td><td>{{.Active}}</td><td>{{.Waiting}}</td><td>{{.MaxConcurrent}}</td><td>{{.TotalNum}}</td><td>{{.RejectNum}}</td></tr>
+{{end}}</table>{{end}}
+{{if .RecentErrors}}<table><tr><th>time</th><th>request</th><th>status</th><th>error</th></tr>
+{{range .RecentErrors}}<tr><td>{{.Time.Format "2006-01-02 15:04:05.000"}}</td><td>{{.Method}} {{.Host}}{{.Path}}</td><td>{{.Status}}</td><td>{{.Error}}</td></tr>
+{{end}}</table>{{end}}
+{{else}}<p>no http pools</p>
+{{end}}</body></html>
+`))
+
+// HTTPPoolsHandler 调试页面，列出所有连接池的配置和状态。
+// 请求带format=json参数或者Accept为application/json时返回JSON，否则返回HTML，name参数只返回指定名字的连接池
+func HTTPPoolsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Query().Get("name")
+		infos := []HTTPPoolInfo{}
+		for _, cp := range HTTPPools() {
+			if name == "" || cp.GetName() == name {
+				infos = append(infos, cp.Info())
+			}
+		}
+		if r.URL.Query().Get("format") == "json" || strings.Contains(r.Header.Get("Accept"), "application/json") {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(infos)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		httpPoolsTemplate.Execute(w, infos)
+	})
+}
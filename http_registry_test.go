@@ -0,0 +1,96 @@
+package goutils
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_HTTPPoolRegistry(t *testing.T) {
+	pool := NewHTTPConnectionPoolWithOptions(WithName("registry-a"), WithPoolNum(1))
+	if LookupHTTPPool("registry-a") != pool {
+		t.Error("pool should register itself")
+	}
+	pool.SetName("registry-b")
+	if LookupHTTPPool("registry-a") != nil || LookupHTTPPool("registry-b") != pool {
+		t.Error("renamed pool should be found by new name")
+	}
+	pool.Close()
+	if LookupHTTPPool("registry-b") != nil {
+		t.Error("closed pool should unregister")
+	}
+}
+
+func Test_HTTPPoolInfo(t *testing.T) {
+	upstream := NewMockUpstream()
+	defer upstream.Close()
+	upstream.Script("/error", MockResponse{Status: http.StatusBadGateway})
+	upstream.Script("/slow", MockResponse{Delay: 200 * time.Millisecond})
+	pool := NewHTTPConnectionPoolWithOptions(WithName("registry-info"), WithTimeout(time.Second), WithPoolNum(2), WithAdmission(HTTPAdmission{Policy: AdmissionWait}), WithCoalesce(HTTPCoalesce{}))
+	defer pool.Close()
+
+	pool.Request(newGetRequest(upstream.URL + "/error?token=abc"))
+	pool.Request(newGetRequest("http://127.0.0.1:1/refused?token=SECRET"))
+	done := make(chan bool)
+	go func() {
+		response, _ := pool.Request(newGetRequest(upstream.URL + "/slow"))
+		CloseResponse(response)
+		done <- true
+	}()
+	time.Sleep(100 * time.Millisecond)
+	info := pool.Info()
+	<-done
+	if info.Name != "registry-info" || info.PoolNum != 2 || info.Admission != "wait" || info.ActiveNum != 1 || info.Utilization != 0.5 {
+		t.Errorf("unexpected info %+v", info)
+	}
+	if len(info.Features) != 1 || info.Features[0] != "coalesce" {
+		t.Errorf("unexpected features %v", info.Features)
+	}
+	if len(info.RecentErrors) != 2 {
+		t.Fatalf("expected 2 recent errors, got %v", info.RecentErrors)
+	}
+	if strings.Contains(info.RecentErrors[0].Error, "SECRET") || !strings.Contains(info.RecentErrors[0].Error, `"http://127.0.0.1:1/refused"`) {
+		t.Errorf("recent error leaked query: %s", info.RecentErrors[0].Error)
+	}
+	if info.RecentErrors[0].Path != "/refused" || info.RecentErrors[1].Status != http.StatusBadGateway || info.RecentErrors[1].Path != "/error" {
+		t.Errorf("unexpected recent errors %+v", info.RecentErrors)
+	}
+}
+
+func Test_HTTPErrorRing(t *testing.T) {
+	r := newHTTPErrorRing(3)
+	for _, path := range []string{"/1", "/2", "/3", "/4"} {
+		r.add(HTTPPoolError{Path: path})
+	}
+	list := r.list()
+	if len(list) != 3 || list[0].Path != "/4" || list[2].Path != "/2" {
+		t.Errorf("unexpected ring order %v", list)
+	}
+}
+
+func Test_HTTPPoolsHandler(t *testing.T) {
+	pool := NewHTTPConnectionPoolWithOptions(WithName("registry-handler"), WithPoolNum(1))
+	defer pool.Close()
+	pool.Request(newGetRequest("http://127.0.0.1:1/down"))
+	handler := HTTPPoolsHandler()
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/debug/pools?format=json&name=registry-handler", nil))
+	var infos []HTTPPoolInfo
+	if err := json.Unmarshal(recorder.Body.Bytes(), &infos); err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(infos) != 1 || infos[0].Name != "registry-handler" || len(infos[0].RecentErrors) != 1 {
+		t.Errorf("unexpected json %s", recorder.Body.String())
+	}
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/debug/pools", nil))
+	body := recorder.Body.String()
+	if !strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/html") || !strings.Contains(body, "<h2>registry-handler</h2>") || !strings.Contains(body, "127.0.0.1:1/down") {
+		t.Errorf("unexpected html %s", body)
+	}
+}
@@ -0,0 +1,153 @@
+package goutils
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var errorNoAddress = errors.New("ERROR_HTTP_NO_ADDRESS")
+
+const (
+	defaultResolverTTL = time.Minute
+	resolverTimeout    = 5 * time.Second
+)
+
+// HTTPResolver 带缓存的DNS解析配置，用于连接池新建连接时解析域名
+type HTTPResolver struct {
+	TTL    time.Duration                                            //缓存时间，默认1分钟，过期后先返回旧结果并在后台刷新
+	Hosts  map[string][]string                                      //静态hosts，优先于DNS，可用于测试或者没有DNS的环境
+	Lookup func(ctx context.Context, host string) ([]string, error) //解析函数，默认net.DefaultResolver.LookupHost
+}
+
+// dnsEntry 一个域名的解析结果，ready关闭前第一次解析还没有完成
+type dnsEntry struct {
+	addrs      []string
+	err        error
+	expire     time.Time
+	refreshing bool
+	ready      chan bool
+	next       uint32 //轮询起点，使连接分散到所有ip
+}
+
+// staticHost 静态hosts中的一个域名，和dnsEntry一样轮询起点
+type staticHost struct {
+	addrs []string
+	next  uint32
+}
+
+type httpResolver struct {
+	config  HTTPResolver
+	lock    *sync.Mutex
+	entries map[string]*dnsEntry
+	hosts   map[string]*staticHost //创建时从Hosts复制，之后只读
+}
+
+func newHTTPResolver(config HTTPResolver) *httpResolver {
+	if config.TTL <= 0 {
+		config.TTL = defaultResolverTTL
+	}
+	if config.Lookup == nil {
+		config.Lookup = net.DefaultResolver.LookupHost
+	}
+	hosts := make(map[string]*staticHost, len(config.Hosts))
+	for host, addrs := range config.Hosts {
+		hosts[host] = &staticHost{addrs: append([]string(nil), addrs...)}
+	}
+	return &httpResolver{config: config, lock: new(sync.Mutex), entries: make(map[string]*dnsEntry), hosts: hosts}
+}
+
+// resolve 返回域名的所有ip以及本次连接使用的起始下标
+func (r *httpResolver) resolve(ctx context.Context, host string) ([]string, int, error) {
+	if static, ok := r.hosts[host]; ok {
+		if len(static.addrs) == 0 {
+			return nil, 0, errorNoAddress
+		}
+		next := atomic.AddUint32(&static.next, 1)
+		return static.addrs, int(next % uint32(len(static.addrs))), nil
+	}
+	r.lock.Lock()
+	entry, ok := r.entries[host]
+	if !ok {
+		entry = &dnsEntry{ready: make(chan bool)}
+		r.entries[host] = entry
+		r.lock.Unlock()
+		r.lookup(host, entry)
+	} else {
+		if !entry.refreshing && entry.addrs != nil && time.Now().After(entry.expire) {
+			entry.refreshing = true
+			go r.lookup(host, entry)
+		}
+		r.lock.Unlock()
+	}
+	select {
+	case <-entry.ready:
+	case <-ctx.Done():
+		return nil, 0, ctx.Err()
+	}
+	r.lock.Lock()
+	addrs, err := entry.addrs, entry.err
+	r.lock.Unlock()
+	if err != nil {
+		return nil, 0, err
+	}
+	next := atomic.AddUint32(&entry.next, 1)
+	return addrs, int(next % uint32(len(addrs))), nil
+}
+
+// lookup 解析域名并更新缓存，刷新失败时保留旧结果，第一次解析失败时删除缓存以便下次重试
+func (r *httpResolver) lookup(host string, entry *dnsEntry) {
+	ctx, cancel := context.WithTimeout(context.Background(), resolverTimeout)
+	addrs, err := r.config.Lookup(ctx, host)
+	cancel()
+	if err == nil && len(addrs) == 0 {
+		err = errorNoAddress
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	first := entry.addrs == nil
+	entry.refreshing = false
+	if err == nil {
+		entry.addrs = addrs
+		entry.expire = time.Now().Add(r.config.TTL)
+	} else if first {
+		entry.err = err
+		if r.entries[host] == entry {
+			delete(r.entries, host)
+		}
+	} else {
+		Log.Warning("dns refresh host=%s err=%s", host, err.Error())
+		entry.expire = time.Now().Add(r.config.TTL)
+	}
+	if first {
+		close(entry.ready)
+	}
+}
+
+// dialContext 包装dial函数，域名按缓存解析，从轮询起点开始依次尝试所有ip
+func (r *httpResolver) dialContext(dial func(ctx context.Context, network, address string) (net.Conn, error)) func(ctx context.Context, network, address string) (net.Conn, error) {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(address)
+		if err != nil || net.ParseIP(host) != nil {
+			return dial(ctx, network, address)
+		}
+		addrs, start, err := r.resolve(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		for i := range addrs {
+			var conn net.Conn
+			addr := addrs[(start+i)%len(addrs)]
+			if conn, err = dial(ctx, network, net.JoinHostPort(addr, port)); err == nil {
+				return conn, nil
+			}
+			if ctx.Err() != nil {
+				break
+			}
+		}
+		return nil, err
+	}
+}
@@ -0,0 +1,130 @@
+package goutils
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_HTTPResolverHosts(t *testing.T) {
+	upstream := NewMockUpstream()
+	defer upstream.Close()
+	upstream.SetDefault(MockResponse{Body: "ok"})
+	_, port, _ := net.SplitHostPort(upstream.Addr())
+	pool := NewHTTPConnectionPoolWithOptions(WithTimeout(time.Second), WithPoolNum(2), WithResolver(HTTPResolver{
+		Hosts: map[string][]string{"api.test": {"127.0.0.1"}},
+		Lookup: func(ctx context.Context, host string) ([]string, error) {
+			return nil, errors.New("no dns")
+		},
+	}))
+	defer pool.Close()
+	response, err := pool.Request(newGetRequest("http://api.test:" + port + "/"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	body, _ := io.ReadAll(response.Body)
+	response.Body.Close()
+	if string(body) != "ok" {
+		t.Errorf("unexpected body %q", body)
+	}
+	if _, err = pool.Request(newGetRequest("http://other.test:" + port + "/")); err == nil {
+		t.Error("lookup error should fail the request")
+	}
+}
+
+func Test_HTTPResolverCache(t *testing.T) {
+	var lookups int64
+	addrs := []string{"10.0.0.1"}
+	lock := new(sync.Mutex)
+	r := newHTTPResolver(HTTPResolver{
+		TTL: 50 * time.Millisecond,
+		Lookup: func(ctx context.Context, host string) ([]string, error) {
+			atomic.AddInt64(&lookups, 1)
+			time.Sleep(10 * time.Millisecond)
+			lock.Lock()
+			defer lock.Unlock()
+			return addrs, nil
+		},
+	})
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, _, err := r.resolve(context.Background(), "api.test"); err != nil {
+				t.Error(err.Error())
+			}
+		}()
+	}
+	wg.Wait()
+	if lookups != 1 {
+		t.Errorf("expected 1 lookup, got %d", lookups)
+	}
+
+	lock.Lock()
+	addrs = []string{"10.0.0.2"}
+	lock.Unlock()
+	time.Sleep(60 * time.Millisecond)
+	// 过期后先返回旧结果，后台刷新
+	got, _, _ := r.resolve(context.Background(), "api.test")
+	if got[0] != "10.0.0.1" {
+		t.Errorf("expired entry should be served while refreshing, got %v", got)
+	}
+	time.Sleep(30 * time.Millisecond)
+	got, _, _ = r.resolve(context.Background(), "api.test")
+	if n := atomic.LoadInt64(&lookups); got[0] != "10.0.0.2" || n != 2 {
+		t.Errorf("entry not refreshed, got %v after %d lookups", got, n)
+	}
+}
+
+func Test_HTTPResolverHostsSpread(t *testing.T) {
+	r := newHTTPResolver(HTTPResolver{Hosts: map[string][]string{"api.test": {"10.0.0.1", "10.0.0.2"}, "web.test": {"10.0.1.1"}}})
+	used := make(map[int]int)
+	for i := 0; i < 4; i++ {
+		addrs, start, err := r.resolve(context.Background(), "api.test")
+		if err != nil || len(addrs) != 2 {
+			t.Fatalf("addrs:%v error:%v", addrs, err)
+		}
+		used[start]++
+	}
+	if used[0] != 2 || used[1] != 2 {
+		t.Errorf("static hosts not spread: %v", used)
+	}
+	if _, start, _ := r.resolve(context.Background(), "web.test"); start != 0 {
+		t.Errorf("start:%d", start)
+	}
+}
+
+func Test_HTTPResolverSpread(t *testing.T) {
+	r := newHTTPResolver(HTTPResolver{Lookup: func(ctx context.Context, host string) ([]string, error) {
+		return []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}, nil
+	}})
+	var dialed []string
+	dial := r.dialContext(func(ctx context.Context, network, address string) (net.Conn, error) {
+		dialed = append(dialed, address)
+		if address == "10.0.0.2:80" {
+			return nil, errors.New("refused")
+		}
+		client, server := net.Pipe()
+		server.Close()
+		return client, nil
+	})
+	used := make(map[string]bool)
+	for i := 0; i < 6; i++ {
+		dialed = nil
+		conn, err := dial(context.Background(), "tcp", "api.test:80")
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		conn.Close()
+		used[dialed[len(dialed)-1]] = true
+	}
+	if len(used) != 2 || !used["10.0.0.1:80"] || !used["10.0.0.3:80"] {
+		t.Errorf("connections not spread across ips: %v", used)
+	}
+}
@@ -0,0 +1,219 @@
+package goutils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var errorRangeMismatch = errors.New("ERROR_HTTP_RANGE_MISMATCH")
+
+// ProgressFunc 读写body的进度回调，done为已经传输的字节数，total未知时为-1
+type ProgressFunc func(done, total int64)
+
+// progressBody 读取时回调进度
+type progressBody struct {
+	io.ReadCloser
+	done     int64
+	total    int64
+	progress ProgressFunc
+}
+
+func (b *progressBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		b.done += int64(n)
+		b.progress(b.done, b.total)
+	}
+	return n, err
+}
+
+// streamBody 流式响应的body，读完或者关闭后通知worker释放
+type streamBody struct {
+	io.ReadCloser
+	once     *sync.Once
+	released chan bool
+}
+
+func newStreamBody(body io.ReadCloser) *streamBody {
+	return &streamBody{ReadCloser: body, once: new(sync.Once), released: make(chan bool)}
+}
+
+func (b *streamBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil {
+		b.release()
+	}
+	return n, err
+}
+
+func (b *streamBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.release()
+	return err
+}
+
+func (b *streamBody) release() {
+	b.once.Do(func() { close(b.released) })
+}
+
+// wrapUpload 上传body时回调进度
+func wrapUpload(request *http.Request, progress ProgressFunc) {
+	if progress == nil || request.Body == nil || request.Body == http.NoBody {
+		return
+	}
+	total := request.ContentLength
+	if total <= 0 {
+		total = -1
+	}
+	request.Body = &progressBody{ReadCloser: request.Body, total: total, progress: progress}
+}
+
+// wrapStream 包装响应body，返回nil表示不是流式请求
+func wrapStream(httpData *HTTPData, response *http.Response) *streamBody {
+	if httpData.Progress != nil {
+		response.Body = &progressBody{ReadCloser: response.Body, total: response.ContentLength, progress: httpData.Progress}
+	}
+	if !httpData.Stream {
+		return nil
+	}
+	stream := newStreamBody(response.Body)
+	response.Body = stream
+	return stream
+}
+
+// holdStream worker等待流式响应读完或者关闭，超过截止时间时关闭body
+func holdStream(ctx context.Context, stream *streamBody, response *http.Response) {
+	select {
+	case <-stream.released:
+	case <-ctx.Done():
+		response.Body.Close()
+	}
+}
+
+// Stream 流式请求，返回后worker一直被占用到body读完或者关闭，调用方必须关闭body。
+// timeout覆盖读取body的时间，0表示使用连接池超时时间，progress可以为nil
+func (cp *HTTPConnectionPool) Stream(request *http.Request, timeout time.Duration, progress ProgressFunc) (*http.Response, error) {
+	httpData := NewHTTPData(request)
+	httpData.Stream = true
+	httpData.Timeout = timeout
+	httpData.Progress = progress
+	cp.Do(httpData)
+	return httpData.Response, httpData.Err
+}
+
+// HTTPDownload 断点续传下载配置
+type HTTPDownload struct {
+	Timeout  time.Duration //单次请求的超时时间，包括读取body，0表示使用连接池超时时间
+	Retries  int           //中断后从断点继续下载的次数
+	Header   http.Header   //额外的请求头
+	Progress ProgressFunc  //下载进度，done包括之前已经下载的部分
+}
+
+// Download 下载到文件，文件已经存在时用Range请求从文件末尾继续下载，
+// 服务端不支持Range时从头下载。中断后按Retries从断点继续
+func (cp *HTTPConnectionPool) Download(ctx context.Context, rawurl, path string, download HTTPDownload) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	validator := ""
+	for retry := 0; ; retry++ {
+		err = cp.downloadOnce(ctx, rawurl, file, &validator, download)
+		if err == nil || retry >= download.Retries || ctx.Err() != nil {
+			return err
+		}
+		if _, ok := err.(*HTTPStatusError); ok || err == errorRangeMismatch {
+			return err
+		}
+		Log.Warning("download url:%s interrupted, retry:%d, err:%s", rawurl, retry+1, err.Error())
+	}
+}
+
+// downloadOnce 从文件末尾继续下载一次，validator记录ETag或者Last-Modified，续传时用于If-Range
+func (cp *HTTPConnectionPool) downloadOnce(ctx context.Context, rawurl string, file *os.File, validator *string, download HTTPDownload) error {
+	offset, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequestWithContext(ctx, "GET", rawurl, nil)
+	if err != nil {
+		return err
+	}
+	for key, values := range download.Header {
+		request.Header[key] = values
+	}
+	if offset > 0 {
+		request.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		if *validator != "" {
+			request.Header.Set("If-Range", *validator)
+		}
+	}
+	httpData := NewHTTPData(request)
+	httpData.Stream = true
+	httpData.Timeout = download.Timeout
+	if err := cp.Do(httpData); err != nil {
+		return err
+	}
+	response := httpData.Response
+	defer response.Body.Close()
+	switch {
+	case response.StatusCode == http.StatusPartialContent:
+		if start, ok := parseContentRangeStart(response.Header.Get("Content-Range")); !ok || start != offset {
+			return errorRangeMismatch
+		}
+	case response.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0:
+		// 文件已经下载完整
+		if response.Header.Get("Content-Range") == fmt.Sprintf("bytes */%d", offset) {
+			return nil
+		}
+		return errorRangeMismatch
+	case response.StatusCode/100 == 2:
+		if err := file.Truncate(0); err != nil {
+			return err
+		}
+		if offset, err = file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+	default:
+		body, _ := io.ReadAll(io.LimitReader(response.Body, int64(statusErrorBodySize)))
+		return newStatusError(request, response.StatusCode, body)
+	}
+	if etag := response.Header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		*validator = etag
+	} else if lastModified := response.Header.Get("Last-Modified"); lastModified != "" {
+		*validator = lastModified
+	}
+	var body io.Reader = response.Body
+	if download.Progress != nil {
+		total := int64(-1)
+		if response.ContentLength >= 0 {
+			total = offset + response.ContentLength
+		}
+		body = &progressBody{ReadCloser: response.Body, done: offset, total: total, progress: download.Progress}
+	}
+	_, err = io.Copy(file, body)
+	return err
+}
+
+// parseContentRangeStart 解析Content-Range: bytes start-end/total中的start
+func parseContentRangeStart(value string) (int64, bool) {
+	if !strings.HasPrefix(value, "bytes ") {
+		return 0, false
+	}
+	value = strings.TrimPrefix(value, "bytes ")
+	index := strings.IndexByte(value, '-')
+	if index <= 0 {
+		return 0, false
+	}
+	start, err := strconv.ParseInt(value[:index], 10, 64)
+	return start, err == nil
+}
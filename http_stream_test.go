@@ -0,0 +1,197 @@
+package goutils
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var streamContent = strings.Repeat("0123456789", 1000)
+
+func newStreamServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/slow":
+			for i := 0; i < 100; i++ {
+				w.Write([]byte("x"))
+				w.(http.Flusher).Flush()
+				select {
+				case <-time.After(20 * time.Millisecond):
+				case <-r.Context().Done():
+					return
+				}
+			}
+		case "/upload":
+			body, _ := io.ReadAll(r.Body)
+			w.Write(body)
+		default:
+			w.Header().Set("ETag", `"v1"`)
+			http.ServeContent(w, r, "file", time.Time{}, strings.NewReader(streamContent))
+		}
+	}))
+}
+
+func Test_HTTPStreamHoldsWorker(t *testing.T) {
+	server := newStreamServer()
+	defer server.Close()
+	pool := NewHTTPConnectionPool(time.Second, 1)
+	defer pool.Close()
+	request, _ := http.NewRequest("GET", server.URL+"/file", nil)
+	response, err := pool.Stream(request, 0, nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	other := NewHTTPData(newGetRequest(server.URL + "/file"))
+	other.Timeout = 100 * time.Millisecond
+	if pool.Do(other) != errorRequestCallTimeout {
+		t.Errorf("expect worker held by stream, err:%v", other.Err)
+	}
+	body, err := io.ReadAll(response.Body)
+	response.Body.Close()
+	if err != nil || string(body) != streamContent {
+		t.Errorf("body len:%d err:%v", len(body), err)
+	}
+	// 等待worker丢弃已经超时的请求
+	for atomic.LoadInt64(&pool.pendingNum) > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := requestBody(pool, server.URL+"/file"); err != nil {
+		t.Errorf("err:%v", err)
+	}
+}
+
+func Test_HTTPStreamDeadline(t *testing.T) {
+	server := newStreamServer()
+	defer server.Close()
+	pool := NewHTTPConnectionPool(time.Second, 1)
+	defer pool.Close()
+	request, _ := http.NewRequest("GET", server.URL+"/slow", nil)
+	response, err := pool.Stream(request, 100*time.Millisecond, nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer response.Body.Close()
+	start := time.Now()
+	if _, err := io.ReadAll(response.Body); err == nil {
+		t.Error("expect deadline error while reading body")
+	}
+	if cost := time.Since(start); cost > 500*time.Millisecond {
+		t.Errorf("cost:%v", cost)
+	}
+}
+
+func Test_HTTPStreamProgress(t *testing.T) {
+	server := newStreamServer()
+	defer server.Close()
+	pool := NewHTTPConnectionPool(time.Second, 1)
+	defer pool.Close()
+	var done, total int64
+	request, _ := http.NewRequest("GET", server.URL+"/file", nil)
+	response, err := pool.Stream(request, 0, func(d, t int64) {
+		done, total = d, t
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	io.Copy(io.Discard, response.Body)
+	response.Body.Close()
+	if done != int64(len(streamContent)) || total != int64(len(streamContent)) {
+		t.Errorf("done:%d total:%d", done, total)
+	}
+
+	var uploaded int64
+	request, _ = http.NewRequest("POST", server.URL+"/upload", strings.NewReader(streamContent))
+	httpData := NewHTTPData(request)
+	httpData.UploadProgress = func(d, t int64) { uploaded = d }
+	if err := pool.Do(httpData); err != nil {
+		t.Fatal(err.Error())
+	}
+	CloseResponse(httpData.Response)
+	if uploaded != int64(len(streamContent)) {
+		t.Errorf("uploaded:%d", uploaded)
+	}
+}
+
+func Test_HTTPDownloadResume(t *testing.T) {
+	server := newStreamServer()
+	defer server.Close()
+	pool := NewHTTPConnectionPool(time.Second, 2)
+	defer pool.Close()
+	path := filepath.Join(t.TempDir(), "file")
+	os.WriteFile(path, []byte(streamContent[:3000]), 0644)
+	var first, last int64 = -1, 0
+	err := pool.Download(context.Background(), server.URL+"/file", path, HTTPDownload{
+		Progress: func(done, total int64) {
+			if first < 0 {
+				first = done
+			}
+			last = total
+		},
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	data, _ := os.ReadFile(path)
+	if string(data) != streamContent {
+		t.Errorf("content len:%d", len(data))
+	}
+	if first <= 3000 || last != int64(len(streamContent)) {
+		t.Errorf("first:%d total:%d", first, last)
+	}
+	// 已经下载完整
+	if err := pool.Download(context.Background(), server.URL+"/file", path, HTTPDownload{}); err != nil {
+		t.Errorf("err:%v", err)
+	}
+}
+
+func Test_HTTPDownloadRetry(t *testing.T) {
+	var count int64
+	var ranges []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		if atomic.AddInt64(&count, 1) == 1 {
+			w.Header().Set("Content-Length", "10000")
+			w.Write([]byte(streamContent[:4000]))
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+		http.ServeContent(w, r, "file", time.Time{}, strings.NewReader(streamContent))
+	}))
+	defer server.Close()
+	pool := NewHTTPConnectionPool(time.Second, 2)
+	defer pool.Close()
+	path := filepath.Join(t.TempDir(), "file")
+	if err := pool.Download(context.Background(), server.URL, path, HTTPDownload{}); err == nil {
+		t.Error("expect interrupted")
+	}
+	os.Remove(path)
+	atomic.StoreInt64(&count, 0)
+	ranges = nil
+	if err := pool.Download(context.Background(), server.URL, path, HTTPDownload{Retries: 1}); err != nil {
+		t.Fatal(err.Error())
+	}
+	data, _ := os.ReadFile(path)
+	if !bytes.Equal(data, []byte(streamContent)) {
+		t.Errorf("content len:%d", len(data))
+	}
+	if len(ranges) != 2 || ranges[1] != "bytes=4000-" {
+		t.Errorf("ranges:%v", ranges)
+	}
+}
+
+func Test_ParseContentRangeStart(t *testing.T) {
+	if start, ok := parseContentRangeStart("bytes 100-199/200"); !ok || start != 100 {
+		t.Errorf("start:%d ok:%v", start, ok)
+	}
+	if _, ok := parseContentRangeStart("bytes */200"); ok {
+		t.Fail()
+	}
+}
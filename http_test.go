@@ -1,7 +1,10 @@
 package goutils
 
 import (
+	"context"
+	"io"
 	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 )
@@ -21,11 +24,13 @@ func Test_HTTPConnectionPoolNagativePoolNum(t *testing.T) {
 }
 
 func Test_HTTPRequestOK(t *testing.T) {
+	upstream := NewMockUpstream()
+	defer upstream.Close()
 	pool := NewHTTPConnectionPool(2*time.Second, 1)
 	if pool == nil {
 		t.Fail()
 	}
-	request, err := http.NewRequest("GET", "http://www.mgtv.com", nil)
+	request, err := http.NewRequest("GET", upstream.URL, nil)
 	if err != nil {
 		t.Error(err.Error())
 		t.Fail()
@@ -56,11 +61,13 @@ func Test_HTTPRequestNil(t *testing.T) {
 }
 
 func Test_HTTPRequestNoPool(t *testing.T) {
+	upstream := NewMockUpstream()
+	defer upstream.Close()
 	pool := NewHTTPConnectionPool(2*time.Second, 0)
 	if pool == nil {
 		t.Fail()
 	}
-	request, err := http.NewRequest("GET", "http://www.mgtv.com", nil)
+	request, err := http.NewRequest("GET", upstream.URL, nil)
 	if err != nil {
 		t.Error(err.Error())
 		t.Fail()
@@ -77,11 +84,14 @@ func Test_HTTPRequestNoPool(t *testing.T) {
 }
 
 func Test_HTTPRequestTimeout(t *testing.T) {
+	upstream := NewMockUpstream()
+	defer upstream.Close()
+	upstream.SetDefault(MockResponse{Delay: 50 * time.Millisecond})
 	pool := NewHTTPConnectionPool(0*time.Second, 1)
 	if pool == nil {
 		t.Fail()
 	}
-	request, err := http.NewRequest("GET", "http://www.mgtv.com", nil)
+	request, err := http.NewRequest("GET", upstream.URL, nil)
 	if err != nil {
 		t.Error(err.Error())
 		t.Fail()
@@ -98,13 +108,15 @@ func Test_HTTPRequestTimeout(t *testing.T) {
 }
 
 func Test_HTTPBatchRequest(t *testing.T) {
+	upstream := NewMockUpstream()
+	defer upstream.Close()
 	pool := NewHTTPConnectionPool(2*time.Second, 10)
 	if pool == nil {
 		t.Fail()
 	}
 	httpdatas := make([]*HTTPData, 0, 10)
 	for i := 0; i < 10; i++ {
-		request, err := http.NewRequest("GET", "http://www.mgtv.com", nil)
+		request, err := http.NewRequest("GET", upstream.URL, nil)
 		if err != nil {
 			t.Error(err.Error())
 			t.Fail()
@@ -123,13 +135,16 @@ func Test_HTTPBatchRequest(t *testing.T) {
 }
 
 func Test_HTTPBatchRequestPoolFull(t *testing.T) {
+	upstream := NewMockUpstream()
+	defer upstream.Close()
+	upstream.SetDefault(MockResponse{Delay: 50 * time.Millisecond})
 	pool := NewHTTPConnectionPool(2*time.Second, 1)
 	if pool == nil {
 		t.Fail()
 	}
 	httpdatas := make([]*HTTPData, 0, 20)
 	for i := 0; i < 10; i++ {
-		request, err := http.NewRequest("GET", "http://www.mgtv.com", nil)
+		request, err := http.NewRequest("GET", upstream.URL, nil)
 		if err != nil {
 			t.Error(err.Error())
 			t.Fail()
@@ -153,13 +168,16 @@ func Test_HTTPBatchRequestPoolFull(t *testing.T) {
 }
 
 func Test_HTTPBatchRequestTimeOut(t *testing.T) {
+	upstream := NewMockUpstream()
+	defer upstream.Close()
+	upstream.SetDefault(MockResponse{Delay: 50 * time.Millisecond})
 	pool := NewHTTPConnectionPool(1*time.Millisecond, 20)
 	if pool == nil {
 		t.Fail()
 	}
 	httpdatas := make([]*HTTPData, 0, 20)
 	for i := 0; i < 10; i++ {
-		request, err := http.NewRequest("GET", "http://www.mgtv.com", nil)
+		request, err := http.NewRequest("GET", upstream.URL, nil)
 		if err != nil {
 			t.Error(err.Error())
 			t.Fail()
@@ -181,3 +199,253 @@ func Test_HTTPBatchRequestTimeOut(t *testing.T) {
 	}
 	t.Logf(pool.Status())
 }
+
+func Test_HTTPSetPoolNum(t *testing.T) {
+	pool := NewHTTPConnectionPool(time.Second, 4)
+	pool.SetPoolNum(8)
+	if pool.GetPoolNum() != 8 {
+		t.Fail()
+	}
+	time.Sleep(100 * time.Millisecond)
+	pool.SetPoolNum(2)
+	time.Sleep(100 * time.Millisecond)
+	pool.lock.Lock()
+	workerNum := pool.workerNum
+	pool.lock.Unlock()
+	if workerNum != 2 {
+		t.Errorf("worker num:%d", workerNum)
+	}
+	t.Log(pool.Status())
+}
+
+func Test_HTTPSetTimeout(t *testing.T) {
+	pool := NewHTTPConnectionPool(time.Second, 1)
+	pool.SetTimeout(2 * time.Second)
+	if pool.GetTimeout() != 2*time.Second {
+		t.Fail()
+	}
+}
+
+func Test_HTTPClose(t *testing.T) {
+	pool := NewHTTPConnectionPool(time.Second, 2)
+	time.Sleep(50 * time.Millisecond)
+	pool.Close()
+	pool.Close()
+	request, _ := http.NewRequest("GET", "http://127.0.0.1/", nil)
+	if _, err := pool.Request(request); err != errorRequestPoolClosed {
+		t.Errorf("request err:%v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	pool.lock.Lock()
+	workerNum := pool.workerNum
+	pool.lock.Unlock()
+	if workerNum != 0 {
+		t.Errorf("worker num:%d", workerNum)
+	}
+}
+
+func Test_HTTPCloseSkipsCache(t *testing.T) {
+	upstream := NewMockUpstream()
+	defer upstream.Close()
+	upstream.SetDefault(MockResponse{Header: http.Header{"Cache-Control": {"max-age=60"}}, Body: "cached"})
+	pool := NewHTTPConnectionPoolWithOptions(WithTimeout(time.Second), WithPoolNum(2), WithCache(HTTPCache{}), WithCoalesce(HTTPCoalesce{}))
+	response, err := pool.Request(newGetRequest(upstream.URL))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	CloseResponse(response)
+	pool.Close()
+	if _, err = pool.Request(newGetRequest(upstream.URL)); err != errorRequestPoolClosed {
+		t.Errorf("request err:%v", err)
+	}
+	httpDatas := []*HTTPData{NewHTTPData(newGetRequest(upstream.URL)), NewHTTPData(newGetRequest(upstream.URL))}
+	pool.BatchRequest(httpDatas)
+	for _, httpData := range httpDatas {
+		if httpData.Err != errorRequestPoolClosed {
+			t.Errorf("batch err:%v", httpData.Err)
+		}
+	}
+}
+
+func Test_HTTPShutdown(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+	}))
+	defer server.Close()
+	pool := NewHTTPConnectionPool(time.Second, 1)
+	request, _ := http.NewRequest("GET", server.URL, nil)
+	result := make(chan error, 1)
+	go func() {
+		_, err := pool.Request(request)
+		result <- err
+	}()
+	time.Sleep(30 * time.Millisecond)
+	if err := pool.Shutdown(context.Background()); err != nil {
+		t.Error(err.Error())
+	}
+	if err := <-result; err != nil {
+		t.Errorf("request err:%s", err.Error())
+	}
+	if _, err := pool.Request(request); err != errorRequestPoolClosed {
+		t.Errorf("request err:%v", err)
+	}
+}
+
+func Test_HTTPShutdownTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer server.Close()
+	pool := NewHTTPConnectionPool(time.Second, 1)
+	time.Sleep(20 * time.Millisecond)
+	httpdatas := make([]*HTTPData, 0, 2)
+	for i := 0; i < 2; i++ {
+		request, _ := http.NewRequest("GET", server.URL, nil)
+		httpdatas = append(httpdatas, NewHTTPData(request))
+	}
+	done := make(chan bool)
+	go func() {
+		pool.BatchRequest(httpdatas)
+		done <- true
+	}()
+	time.Sleep(30 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := pool.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("shutdown err:%v", err)
+	}
+	<-done
+	if httpdatas[1].Err != errorRequestPoolClosed {
+		t.Errorf("request err:%v", httpdatas[1].Err)
+	}
+}
+
+func Test_HTTPDataTimeout(t *testing.T) {
+	server := newSlowServer(100 * time.Millisecond)
+	defer server.Close()
+	pool := NewHTTPConnectionPool(50*time.Millisecond, 1)
+	defer pool.Close()
+	request, _ := http.NewRequest("GET", server.URL, nil)
+	if _, err := pool.Request(request); err != errorRequestCallTimeout {
+		t.Errorf("request err:%v", err)
+	}
+	httpData := NewHTTPData(request)
+	httpData.Timeout = time.Second
+	if err := pool.Do(httpData); err != nil {
+		t.Errorf("request err:%s", err.Error())
+	}
+	httpData = NewHTTPData(request)
+	httpData.Timeout = time.Second
+	httpData.Deadline = time.Now().Add(10 * time.Millisecond)
+	if err := pool.Do(httpData); err != errorRequestCallTimeout {
+		t.Errorf("request err:%v", err)
+	}
+}
+
+func Test_HTTPDataDeadlineCoversBody(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("head"))
+		w.(http.Flusher).Flush()
+		time.Sleep(200 * time.Millisecond)
+		w.Write([]byte("tail"))
+	}))
+	defer server.Close()
+	pool := NewHTTPConnectionPool(100*time.Millisecond, 1)
+	defer pool.Close()
+	request, _ := http.NewRequest("GET", server.URL, nil)
+	response, err := pool.Request(request)
+	if err != nil {
+		t.Fatalf("request err:%s", err.Error())
+	}
+	defer response.Body.Close()
+	if _, err := io.ReadAll(response.Body); err == nil {
+		t.Fail()
+	}
+}
+
+func Test_HTTPBatchRequestDeadline(t *testing.T) {
+	server := newSlowServer(100 * time.Millisecond)
+	defer server.Close()
+	pool := NewHTTPConnectionPool(150*time.Millisecond, 1)
+	defer pool.Close()
+	time.Sleep(20 * time.Millisecond)
+	httpdatas := make([]*HTTPData, 0, 3)
+	for i := 0; i < 3; i++ {
+		request, _ := http.NewRequest("GET", server.URL, nil)
+		httpdatas = append(httpdatas, NewHTTPData(request))
+	}
+	start := time.Now()
+	pool.BatchRequest(httpdatas)
+	if cost := time.Since(start); cost > 250*time.Millisecond {
+		t.Errorf("batch cost:%v", cost)
+	}
+	if httpdatas[0].Err != nil || httpdatas[1].Err != errorRequestCallTimeout {
+		t.Errorf("request err:%v %v", httpdatas[0].Err, httpdatas[1].Err)
+	}
+}
+
+func Test_HTTPDataReuse(t *testing.T) {
+	server := newSlowServer(50 * time.Millisecond)
+	defer server.Close()
+	pool := NewHTTPConnectionPool(time.Second, 2)
+	defer pool.Close()
+	request, _ := http.NewRequest("GET", server.URL, nil)
+	httpData := NewHTTPData(request)
+	for i := 0; i < 3; i++ {
+		done := make(chan bool)
+		go func() {
+			pool.BatchRequest([]*HTTPData{httpData})
+			done <- true
+		}()
+		select {
+		case <-done:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("batch request %d hang", i)
+		}
+		if httpData.Err != nil {
+			t.Fatalf("batch request %d err:%v", i, httpData.Err)
+		}
+		httpData.Response.Body.Close()
+		if err := pool.Do(httpData); err != nil {
+			t.Fatalf("do %d err:%v", i, err)
+		}
+		httpData.Response.Body.Close()
+	}
+
+	// 超时后重复使用，上一轮的worker不能写入结果
+	httpData.Timeout = 10 * time.Millisecond
+	if err := pool.Do(httpData); err != errorRequestCallTimeout {
+		t.Fatalf("request err:%v", err)
+	}
+	httpData.Timeout = 0
+	httpData.Request, _ = http.NewRequest("GET", server.URL+"/second", nil)
+	if err := pool.Do(httpData); err != nil {
+		t.Fatalf("request err:%v", err)
+	}
+	if httpData.Response.Request.URL.Path != "/second" {
+		t.Errorf("stale response from previous round: %s", httpData.Response.Request.URL.Path)
+	}
+	httpData.Response.Body.Close()
+}
+
+func Test_HTTPPriority(t *testing.T) {
+	server := newSlowServer(50 * time.Millisecond)
+	defer server.Close()
+	pool := NewHTTPConnectionPool(time.Second, 1)
+	defer pool.Close()
+	time.Sleep(20 * time.Millisecond)
+	httpdatas := make([]*HTTPData, 0, 4)
+	for _, priority := range []HTTPPriority{PriorityNormal, PriorityNormal, PriorityCritical, PriorityBestEffort} {
+		request, _ := http.NewRequest("GET", server.URL, nil)
+		httpData := NewHTTPData(request)
+		httpData.Priority = priority
+		httpdatas = append(httpdatas, httpData)
+	}
+	pool.BatchRequest(httpdatas)
+	if httpdatas[0].Err != nil || httpdatas[1].Err != nil || httpdatas[2].Err != nil {
+		t.Errorf("request err:%v %v %v", httpdatas[0].Err, httpdatas[1].Err, httpdatas[2].Err)
+	}
+	if httpdatas[3].Err != errorRequestShed {
+		t.Errorf("request err:%v", httpdatas[3].Err)
+	}
+}
@@ -0,0 +1,502 @@
+package goutils
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"hash/crc32"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	errorNoHealthyEndpoint = errors.New("ERROR_HTTP_NO_HEALTHY_ENDPOINT")
+	errorNoEndpoint        = errors.New("ERROR_HTTP_NO_ENDPOINT")
+
+	defaultOutlierFailures      = 5
+	defaultOutlierEjectDuration = 30 * time.Second
+	defaultOutlierMaxEjectRatio = 0.5
+	defaultHashReplicas         = 100
+)
+
+// HTTPEndpoint 后端节点
+type HTTPEndpoint struct {
+	Addr       string //host:port
+	Scheme     string //为空时沿用请求的scheme
+	Weight     int    //权重，默认1
+	HealthPath string //健康检查路径，为空时使用HTTPHealthCheck.Path
+
+	outstanding   int64 //正在执行的请求数
+	failNum       int64 //连续失败次数
+	ejectedUntil  int64 //被剔除到的时间点，unix纳秒
+	currentWeight int   //平滑加权轮询的当前权重
+	health        httpHealth
+}
+
+// Outstanding 正在执行的请求数
+func (ep *HTTPEndpoint) Outstanding() int64 {
+	return atomic.LoadInt64(&ep.outstanding)
+}
+
+// Ejected 是否因为连续失败被剔除
+func (ep *HTTPEndpoint) Ejected() bool {
+	return time.Now().UnixNano() < atomic.LoadInt64(&ep.ejectedUntil)
+}
+
+// rewrite 把请求发往该节点
+func (ep *HTTPEndpoint) rewrite(request *http.Request) *http.Request {
+	r := new(http.Request)
+	*r = *request
+	u := *request.URL
+	u.Host = ep.Addr
+	if len(ep.Scheme) > 0 {
+		u.Scheme = ep.Scheme
+	}
+	r.URL = &u
+	r.Host = ""
+	return r.WithContext(context.WithValue(request.Context(), originalURLKey{}, request.URL))
+}
+
+type originalURLKey struct{}
+
+type upstreamCallKey struct{}
+
+// upstreamCall 请求选中的节点，拦截器链最内层发出请求后统计结果
+type upstreamCall struct {
+	upstream *HTTPUpstream
+	ep       *HTTPEndpoint
+}
+
+// originalURL 改写前的URL，请求没有被改写时返回request.URL
+func originalURL(request *http.Request) *url.URL {
+	if u, ok := request.Context().Value(originalURLKey{}).(*url.URL); ok {
+		return u
+	}
+	return request.URL
+}
+
+// HTTPBalancer 负载均衡策略，endpoints为当前可用的节点，不为空
+type HTTPBalancer interface {
+	Pick(request *http.Request, endpoints []*HTTPEndpoint) *HTTPEndpoint
+}
+
+type roundRobinBalancer struct {
+	next uint64
+}
+
+// NewRoundRobinBalancer 轮询
+func NewRoundRobinBalancer() HTTPBalancer {
+	return new(roundRobinBalancer)
+}
+
+func (b *roundRobinBalancer) Pick(request *http.Request, endpoints []*HTTPEndpoint) *HTTPEndpoint {
+	next := atomic.AddUint64(&b.next, 1)
+	return endpoints[next%uint64(len(endpoints))]
+}
+
+type weightedBalancer struct {
+	lock *sync.Mutex
+}
+
+// NewWeightedBalancer 平滑加权轮询，同nginx
+func NewWeightedBalancer() HTTPBalancer {
+	return &weightedBalancer{lock: new(sync.Mutex)}
+}
+
+func (b *weightedBalancer) Pick(request *http.Request, endpoints []*HTTPEndpoint) *HTTPEndpoint {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	var best *HTTPEndpoint
+	total := 0
+	for _, ep := range endpoints {
+		weight := ep.Weight
+		if weight <= 0 {
+			weight = 1
+		}
+		total += weight
+		ep.currentWeight += weight
+		if best == nil || ep.currentWeight > best.currentWeight {
+			best = ep
+		}
+	}
+	best.currentWeight -= total
+	return best
+}
+
+type leastOutstandingBalancer struct {
+}
+
+// NewLeastOutstandingBalancer 选择正在执行请求最少的节点
+func NewLeastOutstandingBalancer() HTTPBalancer {
+	return leastOutstandingBalancer{}
+}
+
+func (b leastOutstandingBalancer) Pick(request *http.Request, endpoints []*HTTPEndpoint) *HTTPEndpoint {
+	offset := rand.Intn(len(endpoints))
+	best := endpoints[offset]
+	for i := 1; i < len(endpoints); i++ {
+		ep := endpoints[(offset+i)%len(endpoints)]
+		if ep.Outstanding() < best.Outstanding() {
+			best = ep
+		}
+	}
+	return best
+}
+
+type p2cBalancer struct {
+}
+
+// NewP2CBalancer power of two choices，随机选两个节点取正在执行请求较少的
+func NewP2CBalancer() HTTPBalancer {
+	return p2cBalancer{}
+}
+
+func (b p2cBalancer) Pick(request *http.Request, endpoints []*HTTPEndpoint) *HTTPEndpoint {
+	if len(endpoints) == 1 {
+		return endpoints[0]
+	}
+	i := rand.Intn(len(endpoints))
+	j := rand.Intn(len(endpoints) - 1)
+	if j >= i {
+		j++
+	}
+	if endpoints[j].Outstanding() < endpoints[i].Outstanding() {
+		return endpoints[j]
+	}
+	return endpoints[i]
+}
+
+type consistentHashBalancer struct {
+	lock     *sync.Mutex
+	key      func(request *http.Request) string
+	replicas int
+	members  string //当前哈希环对应的节点列表
+	ring     []uint32
+	nodes    map[uint32]*HTTPEndpoint
+}
+
+// NewConsistentHashBalancer 按key一致性哈希，replicas为每个节点的虚拟节点数，默认100
+func NewConsistentHashBalancer(key func(request *http.Request) string, replicas int) HTTPBalancer {
+	if replicas <= 0 {
+		replicas = defaultHashReplicas
+	}
+	return &consistentHashBalancer{lock: new(sync.Mutex), key: key, replicas: replicas}
+}
+
+func (b *consistentHashBalancer) Pick(request *http.Request, endpoints []*HTTPEndpoint) *HTTPEndpoint {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.build(endpoints)
+	hash := crc32.ChecksumIEEE([]byte(b.key(request)))
+	i := sort.Search(len(b.ring), func(i int) bool { return b.ring[i] >= hash })
+	if i == len(b.ring) {
+		i = 0
+	}
+	return b.nodes[b.ring[i]]
+}
+
+// build 节点列表变化时重建哈希环
+func (b *consistentHashBalancer) build(endpoints []*HTTPEndpoint) {
+	addrs := make([]string, 0, len(endpoints))
+	for _, ep := range endpoints {
+		addrs = append(addrs, ep.Addr)
+	}
+	members := strings.Join(addrs, ",")
+	if members == b.members {
+		return
+	}
+	b.members = members
+	b.ring = make([]uint32, 0, len(endpoints)*b.replicas)
+	b.nodes = make(map[uint32]*HTTPEndpoint, len(endpoints)*b.replicas)
+	for _, ep := range endpoints {
+		for i := 0; i < b.replicas; i++ {
+			hash := crc32.ChecksumIEEE([]byte(ep.Addr + "#" + strconv.Itoa(i)))
+			b.ring = append(b.ring, hash)
+			b.nodes[hash] = ep
+		}
+	}
+	sort.Slice(b.ring, func(i, j int) bool { return b.ring[i] < b.ring[j] })
+}
+
+// HTTPEndpointResolver 后端节点列表来源
+type HTTPEndpointResolver interface {
+	Resolve() ([]*HTTPEndpoint, error)
+}
+
+type staticResolver struct {
+	addrs []string
+}
+
+// NewStaticResolver 固定的节点列表，地址格式同文件列表的一行："host:port [weight]"
+func NewStaticResolver(addrs ...string) HTTPEndpointResolver {
+	return staticResolver{addrs: addrs}
+}
+
+func (r staticResolver) Resolve() ([]*HTTPEndpoint, error) {
+	endpoints := make([]*HTTPEndpoint, 0, len(r.addrs))
+	for _, addr := range r.addrs {
+		ep, err := parseEndpoint(addr)
+		if err != nil {
+			return nil, err
+		}
+		endpoints = append(endpoints, ep)
+	}
+	return endpoints, nil
+}
+
+type fileResolver struct {
+	path string
+}
+
+// NewFileResolver 从文件读取节点列表，每行一个节点："host:port [weight]"，#开头为注释
+func NewFileResolver(path string) HTTPEndpointResolver {
+	return fileResolver{path: path}
+}
+
+func (r fileResolver) Resolve() ([]*HTTPEndpoint, error) {
+	fp, err := os.Open(r.path)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+	var endpoints []*HTTPEndpoint
+	scanner := bufio.NewScanner(fp)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		ep, err := parseEndpoint(line)
+		if err != nil {
+			return nil, err
+		}
+		endpoints = append(endpoints, ep)
+	}
+	return endpoints, scanner.Err()
+}
+
+// parseEndpoint 解析"[scheme://]host:port [weight]"
+func parseEndpoint(line string) (*HTTPEndpoint, error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil, errors.New("empty endpoint")
+	}
+	ep := &HTTPEndpoint{Addr: fields[0], Weight: 1}
+	if i := strings.Index(ep.Addr, "://"); i > 0 {
+		ep.Scheme, ep.Addr = ep.Addr[:i], ep.Addr[i+3:]
+	}
+	if len(fields) > 1 {
+		weight, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, errors.New("invalid endpoint weight: " + line)
+		}
+		ep.Weight = weight
+	}
+	return ep, nil
+}
+
+// HTTPOutlier 被动异常检测配置，节点连续失败(错误、超时或者5xx)达到次数后剔除一段时间
+type HTTPOutlier struct {
+	ConsecutiveFailures int           //连续失败次数，默认5
+	EjectDuration       time.Duration //剔除时长，默认30s
+	MaxEjectRatio       float64       //最多剔除的节点比例，默认0.5
+}
+
+// HTTPUpstream 一组后端节点，连接池按负载均衡策略把请求改写到其中一个节点
+type HTTPUpstream struct {
+	lock       *sync.Mutex
+	resolver   HTTPEndpointResolver
+	balancer   HTTPBalancer
+	outlier    HTTPOutlier
+	endpoints  []*HTTPEndpoint
+	quit       chan bool
+	quitOnce   *sync.Once
+	healthStop chan bool //停止当前的健康检查
+}
+
+// NewHTTPUpstream 构造函数，balancer为nil时使用轮询
+func NewHTTPUpstream(resolver HTTPEndpointResolver, balancer HTTPBalancer) (*HTTPUpstream, error) {
+	if balancer == nil {
+		balancer = NewRoundRobinBalancer()
+	}
+	u := &HTTPUpstream{
+		lock:     new(sync.Mutex),
+		resolver: resolver,
+		balancer: balancer,
+		outlier: HTTPOutlier{
+			ConsecutiveFailures: defaultOutlierFailures,
+			EjectDuration:       defaultOutlierEjectDuration,
+			MaxEjectRatio:       defaultOutlierMaxEjectRatio,
+		},
+		quit:     make(chan bool),
+		quitOnce: new(sync.Once),
+	}
+	if err := u.Reload(); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// SetOutlier 设置被动异常检测参数，ConsecutiveFailures小于0时关闭
+func (u *HTTPUpstream) SetOutlier(outlier HTTPOutlier) {
+	if outlier.ConsecutiveFailures == 0 {
+		outlier.ConsecutiveFailures = defaultOutlierFailures
+	}
+	if outlier.EjectDuration <= 0 {
+		outlier.EjectDuration = defaultOutlierEjectDuration
+	}
+	if outlier.MaxEjectRatio <= 0 {
+		outlier.MaxEjectRatio = defaultOutlierMaxEjectRatio
+	}
+	u.lock.Lock()
+	u.outlier = outlier
+	u.lock.Unlock()
+}
+
+// Reload 重新获取节点列表，已有节点保留统计状态，可在SignalReload.Reload中调用
+func (u *HTTPUpstream) Reload() error {
+	endpoints, err := u.resolver.Resolve()
+	if err != nil {
+		Log.Error("resolve upstream endpoints error:%s", err.Error())
+		return err
+	}
+	if len(endpoints) == 0 {
+		return errorNoEndpoint
+	}
+	u.lock.Lock()
+	old := make(map[string]*HTTPEndpoint, len(u.endpoints))
+	for _, ep := range u.endpoints {
+		old[ep.Scheme+"://"+ep.Addr] = ep
+	}
+	for i, ep := range endpoints {
+		if exist, ok := old[ep.Scheme+"://"+ep.Addr]; ok && exist.Weight == ep.Weight && exist.HealthPath == ep.HealthPath {
+			endpoints[i] = exist
+		}
+	}
+	u.endpoints = endpoints
+	u.lock.Unlock()
+	return nil
+}
+
+// StartRefresh 周期性重新获取节点列表，Close时停止
+func (u *HTTPUpstream) StartRefresh(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				u.Reload()
+			case <-u.quit:
+				return
+			}
+		}
+	}()
+}
+
+// Close 停止后台任务
+func (u *HTTPUpstream) Close() {
+	u.quitOnce.Do(func() {
+		close(u.quit)
+	})
+}
+
+// Endpoints 当前所有节点
+func (u *HTTPUpstream) Endpoints() []*HTTPEndpoint {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	endpoints := make([]*HTTPEndpoint, len(u.endpoints))
+	copy(endpoints, u.endpoints)
+	return endpoints
+}
+
+// available 健康并且没有被剔除的节点
+func (u *HTTPUpstream) available() []*HTTPEndpoint {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	endpoints := make([]*HTTPEndpoint, 0, len(u.endpoints))
+	for _, ep := range u.endpoints {
+		if ep.Healthy() && !ep.Ejected() {
+			endpoints = append(endpoints, ep)
+		}
+	}
+	return endpoints
+}
+
+// pick 选择节点并增加正在执行的请求数，请求结束后需要调用done
+func (u *HTTPUpstream) pick(request *http.Request) (*HTTPEndpoint, error) {
+	endpoints := u.available()
+	if len(endpoints) == 0 {
+		return nil, errorNoHealthyEndpoint
+	}
+	ep := u.balancer.Pick(request, endpoints)
+	atomic.AddInt64(&ep.outstanding, 1)
+	return ep, nil
+}
+
+// bind 在请求的context中记录选中的节点
+func (u *HTTPUpstream) bind(ep *HTTPEndpoint, request *http.Request) *http.Request {
+	return request.WithContext(context.WithValue(request.Context(), upstreamCallKey{}, &upstreamCall{upstream: u, ep: ep}))
+}
+
+// release 请求结束，减少节点正在执行的请求数
+func (u *HTTPUpstream) release(ep *HTTPEndpoint) {
+	atomic.AddInt64(&ep.outstanding, -1)
+}
+
+// observeUpstream 统计发往节点的请求结果，只在拦截器链最内层调用，拦截器自身的错误不计入
+func observeUpstream(request *http.Request, response *http.Response, err error) {
+	if call, ok := request.Context().Value(upstreamCallKey{}).(*upstreamCall); ok {
+		call.upstream.observe(call.ep, response, err)
+	}
+}
+
+// observe 记录请求结果，连续失败达到阈值时剔除节点，调用方或者对冲取消的请求不计入
+func (u *HTTPUpstream) observe(ep *HTTPEndpoint, response *http.Response, err error) {
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	if err == nil && response.StatusCode < http.StatusInternalServerError {
+		atomic.StoreInt64(&ep.failNum, 0)
+		return
+	}
+	failNum := atomic.AddInt64(&ep.failNum, 1)
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	if u.outlier.ConsecutiveFailures < 0 || failNum < int64(u.outlier.ConsecutiveFailures) || ep.Ejected() {
+		return
+	}
+	ejectedNum := 0
+	for _, e := range u.endpoints {
+		if e.Ejected() {
+			ejectedNum++
+		}
+	}
+	if float64(ejectedNum+1) > u.outlier.MaxEjectRatio*float64(len(u.endpoints)) {
+		return
+	}
+	Log.Warning("eject upstream endpoint:%s, consecutive failures:%d", ep.Addr, failNum)
+	atomic.StoreInt64(&ep.failNum, 0)
+	atomic.StoreInt64(&ep.ejectedUntil, time.Now().Add(u.outlier.EjectDuration).UnixNano())
+}
+
+// SetUpstream 设置后端节点，请求的host会被改写为负载均衡选出的节点，nil表示不改写
+func (cp *HTTPConnectionPool) SetUpstream(upstream *HTTPUpstream) {
+	cp.lock.Lock()
+	cp.upstream = upstream
+	cp.lock.Unlock()
+}
+
+func (cp *HTTPConnectionPool) getUpstream() *HTTPUpstream {
+	cp.lock.Lock()
+	defer cp.lock.Unlock()
+	return cp.upstream
+}
@@ -0,0 +1,208 @@
+package goutils
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newNamedServer(name string, status int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte(name))
+	}))
+}
+
+func serverAddr(server *httptest.Server) string {
+	return strings.TrimPrefix(server.URL, "http://")
+}
+
+func requestBody(pool *HTTPConnectionPool, url string) (string, error) {
+	request, _ := http.NewRequest("GET", url, nil)
+	response, err := pool.Request(request)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	return string(body), err
+}
+
+func Test_HTTPUpstreamRoundRobin(t *testing.T) {
+	a, b := newNamedServer("a", 200), newNamedServer("b", 200)
+	defer a.Close()
+	defer b.Close()
+	upstream, err := NewHTTPUpstream(NewStaticResolver(serverAddr(a), serverAddr(b)), nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	pool := NewHTTPConnectionPoolWithOptions(WithTimeout(time.Second), WithPoolNum(2), WithUpstream(upstream))
+	defer pool.Close()
+	counts := make(map[string]int)
+	for i := 0; i < 10; i++ {
+		body, err := requestBody(pool, "http://service/path")
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		counts[body]++
+	}
+	if counts["a"] != 5 || counts["b"] != 5 {
+		t.Errorf("counts:%v", counts)
+	}
+}
+
+func Test_HTTPWeightedBalancer(t *testing.T) {
+	endpoints := []*HTTPEndpoint{{Addr: "a", Weight: 5}, {Addr: "b", Weight: 1}, {Addr: "c", Weight: 1}}
+	balancer := NewWeightedBalancer()
+	counts := make(map[string]int)
+	for i := 0; i < 7; i++ {
+		counts[balancer.Pick(nil, endpoints).Addr]++
+	}
+	if counts["a"] != 5 || counts["b"] != 1 || counts["c"] != 1 {
+		t.Errorf("counts:%v", counts)
+	}
+}
+
+func Test_HTTPLeastOutstandingBalancer(t *testing.T) {
+	endpoints := []*HTTPEndpoint{{Addr: "a", outstanding: 3}, {Addr: "b", outstanding: 1}, {Addr: "c", outstanding: 2}}
+	if NewLeastOutstandingBalancer().Pick(nil, endpoints).Addr != "b" {
+		t.Fail()
+	}
+	endpoints = endpoints[:2]
+	if NewP2CBalancer().Pick(nil, endpoints).Addr != "b" {
+		t.Fail()
+	}
+}
+
+func Test_HTTPConsistentHashBalancer(t *testing.T) {
+	endpoints := []*HTTPEndpoint{{Addr: "a"}, {Addr: "b"}, {Addr: "c"}}
+	balancer := NewConsistentHashBalancer(func(request *http.Request) string {
+		return request.URL.Query().Get("uid")
+	}, 0)
+	picked := make(map[string]string)
+	for _, uid := range []string{"1", "2", "3", "4", "5"} {
+		request, _ := http.NewRequest("GET", "http://service/?uid="+uid, nil)
+		picked[uid] = balancer.Pick(request, endpoints).Addr
+		if balancer.Pick(request, endpoints).Addr != picked[uid] {
+			t.Fail()
+		}
+	}
+	// 去掉一个节点后，原来不在该节点上的key不受影响
+	for uid, addr := range picked {
+		if addr == "c" {
+			continue
+		}
+		request, _ := http.NewRequest("GET", "http://service/?uid="+uid, nil)
+		if balancer.Pick(request, endpoints[:2]).Addr != addr {
+			t.Errorf("uid:%s moved", uid)
+		}
+	}
+}
+
+func Test_HTTPFileResolver(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "endpoints")
+	os.WriteFile(path, []byte("# comment\n127.0.0.1:8080 3\n\nhttps://127.0.0.1:8443\n"), 0644)
+	upstream, err := NewHTTPUpstream(NewFileResolver(path), nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	endpoints := upstream.Endpoints()
+	if len(endpoints) != 2 || endpoints[0].Weight != 3 || endpoints[1].Scheme != "https" || endpoints[1].Addr != "127.0.0.1:8443" {
+		t.Errorf("endpoints:%v", endpoints)
+	}
+	os.WriteFile(path, []byte("127.0.0.1:8080 3\n"), 0644)
+	if err := upstream.Reload(); err != nil {
+		t.Fatal(err.Error())
+	}
+	if reloaded := upstream.Endpoints(); len(reloaded) != 1 || reloaded[0] != endpoints[0] {
+		t.Fail()
+	}
+	os.WriteFile(path, []byte("127.0.0.1:8080 x\n"), 0644)
+	if err := upstream.Reload(); err == nil {
+		t.Fail()
+	}
+}
+
+func Test_HTTPUpstreamOutlier(t *testing.T) {
+	good, bad := newNamedServer("good", 200), newNamedServer("bad", 500)
+	defer good.Close()
+	defer bad.Close()
+	upstream, _ := NewHTTPUpstream(NewStaticResolver(serverAddr(good), serverAddr(bad)), nil)
+	upstream.SetOutlier(HTTPOutlier{ConsecutiveFailures: 2, EjectDuration: time.Minute})
+	pool := NewHTTPConnectionPoolWithOptions(WithTimeout(time.Second), WithPoolNum(1), WithUpstream(upstream))
+	defer pool.Close()
+	for i := 0; i < 6; i++ {
+		requestBody(pool, "http://service/")
+	}
+	for i := 0; i < 4; i++ {
+		if body, _ := requestBody(pool, "http://service/"); body != "good" {
+			t.Errorf("body:%s", body)
+		}
+	}
+	// 最多剔除一半节点
+	good.Close()
+	for i := 0; i < 4; i++ {
+		requestBody(pool, "http://service/")
+	}
+	ejectedNum := 0
+	for _, ep := range upstream.Endpoints() {
+		if ep.Ejected() {
+			ejectedNum++
+		}
+	}
+	if ejectedNum != 1 {
+		t.Errorf("ejected num:%d", ejectedNum)
+	}
+}
+
+func Test_HTTPUpstreamOutlierClientErrors(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(time.Second):
+		case <-r.Context().Done():
+		}
+	}))
+	defer slow.Close()
+	other := newNamedServer("other", 200)
+	defer other.Close()
+	upstream, _ := NewHTTPUpstream(NewStaticResolver(serverAddr(slow), serverAddr(other)), nil)
+	upstream.SetOutlier(HTTPOutlier{ConsecutiveFailures: 1, EjectDuration: time.Minute})
+	pool := NewHTTPConnectionPoolWithOptions(WithTimeout(time.Second), WithPoolNum(2), WithUpstream(upstream))
+	defer pool.Close()
+	// 调用方取消的请求不计入
+	for i := 0; i < 4; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(20*time.Millisecond, cancel)
+		request, _ := http.NewRequestWithContext(ctx, "GET", "http://service/", nil)
+		if response, err := pool.Request(request); err == nil {
+			response.Body.Close()
+		}
+		cancel()
+	}
+	// 拦截器自身的错误不计入
+	pool.Use(func(next RoundTripFunc) RoundTripFunc {
+		return func(request *http.Request) (*http.Response, error) {
+			return nil, errors.New("token error")
+		}
+	})
+	for i := 0; i < 4; i++ {
+		requestBody(pool, "http://service/")
+	}
+	for start := time.Now(); time.Since(start) < time.Second; time.Sleep(10 * time.Millisecond) {
+		if upstream.Endpoints()[0].Outstanding() == 0 && upstream.Endpoints()[1].Outstanding() == 0 {
+			break
+		}
+	}
+	for _, ep := range upstream.Endpoints() {
+		if ep.Ejected() {
+			t.Errorf("endpoint %s ejected", ep.Addr)
+		}
+	}
+}